@@ -1,6 +1,9 @@
 package dag
 
 import (
+	"bytes"
+	"encoding/json"
+	"strings"
 	"testing"
 )
 
@@ -31,3 +34,53 @@ func TestDag(t *testing.T) {
 		panic("should have circle here")
 	}
 }
+
+type namedNode struct {
+	node
+	name string
+}
+
+func (n *namedNode) String() string {
+	return n.name
+}
+
+func TestExport(t *testing.T) {
+	a := &namedNode{name: `a "x"`}
+	b := &namedNode{name: "b"}
+	b.children = []Node{a}
+	d := &Dag{}
+	d.Add(a, b)
+	detectAndPanic(d)
+	opts := ExportOptions{
+		Attrs: func(n Node) NodeAttrs {
+			if n == b {
+				return NodeAttrs{Color: "#ff0000"}
+			}
+			return NodeAttrs{}
+		},
+	}
+	var buf bytes.Buffer
+	d.WriteDOT(&buf, opts)
+	for _, s := range []string{`"n0" [label="a \"x\""]`, `fillcolor="#ff0000"`, `"n1" -> "n0";`} {
+		if !strings.Contains(buf.String(), s) {
+			t.Fatalf("expect %s in dot %s", s, buf.String())
+		}
+	}
+	buf.Reset()
+	opts.Reverse = true
+	d.WriteMermaid(&buf, opts)
+	for _, s := range []string{`n0["a #quot;x#quot;"]`, "n0 --> n1", "style n1 fill:#ff0000"} {
+		if !strings.Contains(buf.String(), s) {
+			t.Fatalf("expect %s in mermaid %s", s, buf.String())
+		}
+	}
+	buf.Reset()
+	d.WriteJSON(&buf, opts)
+	var nodes []JSONNode
+	if err := json.Unmarshal(buf.Bytes(), &nodes); err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 2 || len(nodes[0].Nexts) != 1 || nodes[0].Nexts[0] != "n1" {
+		t.Fatalf("unexpected json %s", buf.String())
+	}
+}
@@ -0,0 +1,149 @@
+package dag
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// NodeAttrs is how a node looks like in exported graphs
+type NodeAttrs struct {
+	// unique id of the node, it's n0, n1... by the index of the node if empty
+	ID    string
+	Label string
+	// fill color like #a5d6a7, no color if empty
+	Color string
+}
+
+// ExportOptions controls how nodes and edges are exported
+type ExportOptions struct {
+	// Attrs returns attributes of a node, the label is the id or String() of
+	// the node if it's not set
+	Attrs func(Node) NodeAttrs
+	// draw edges from Nexts() of a node to the node, it suits the case that
+	// Nexts() are nodes depended on
+	Reverse bool
+}
+
+type exportNode struct {
+	index int
+	attrs NodeAttrs
+	nexts []int
+}
+
+func (d *Dag) exportNodes(opts ExportOptions) []exportNode {
+	index := make(map[Node]int, len(d.nodes))
+	for i, n := range d.nodes {
+		index[n] = i
+	}
+	nodes := make([]exportNode, len(d.nodes))
+	for i, n := range d.nodes {
+		var attrs NodeAttrs
+		if opts.Attrs != nil {
+			attrs = opts.Attrs(n)
+		}
+		if attrs.ID == "" {
+			attrs.ID = "n" + strconv.Itoa(i)
+		}
+		if attrs.Label == "" {
+			if s, ok := n.(fmt.Stringer); ok {
+				attrs.Label = s.String()
+			} else {
+				attrs.Label = attrs.ID
+			}
+		}
+		nodes[i] = exportNode{index: i, attrs: attrs}
+	}
+	for i, n := range d.nodes {
+		for _, next := range n.Nexts() {
+			// nodes not added to the dag are ignored
+			j, ok := index[next]
+			if !ok {
+				continue
+			}
+			if opts.Reverse {
+				nodes[j].nexts = append(nodes[j].nexts, i)
+			} else {
+				nodes[i].nexts = append(nodes[i].nexts, j)
+			}
+		}
+	}
+	return nodes
+}
+
+// WriteDOT writes the dag in Graphviz DOT format
+func (d *Dag) WriteDOT(w io.Writer, opts ExportOptions) error {
+	var b strings.Builder
+	b.WriteString("digraph {\n")
+	b.WriteString("  node [shape=box];\n")
+	nodes := d.exportNodes(opts)
+	for _, n := range nodes {
+		fmt.Fprintf(&b, "  %s [label=%s", strconv.Quote(n.attrs.ID), strconv.Quote(n.attrs.Label))
+		if n.attrs.Color != "" {
+			fmt.Fprintf(&b, ", style=filled, fillcolor=%s", strconv.Quote(n.attrs.Color))
+		}
+		b.WriteString("];\n")
+	}
+	for _, n := range nodes {
+		for _, next := range n.nexts {
+			fmt.Fprintf(&b, "  %s -> %s;\n", strconv.Quote(n.attrs.ID), strconv.Quote(nodes[next].attrs.ID))
+		}
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteMermaid writes the dag as a Mermaid flowchart. Ids of nodes are
+// always n0, n1... since Mermaid is picky about them.
+func (d *Dag) WriteMermaid(w io.Writer, opts ExportOptions) error {
+	var b strings.Builder
+	b.WriteString("flowchart TD\n")
+	nodes := d.exportNodes(opts)
+	for _, n := range nodes {
+		label := strings.ReplaceAll(n.attrs.Label, `"`, "#quot;")
+		fmt.Fprintf(&b, "  n%d[\"%s\"]\n", n.index, label)
+	}
+	for _, n := range nodes {
+		for _, next := range n.nexts {
+			fmt.Fprintf(&b, "  n%d --> n%d\n", n.index, next)
+		}
+	}
+	for _, n := range nodes {
+		if n.attrs.Color != "" {
+			fmt.Fprintf(&b, "  style n%d fill:%s\n", n.index, n.attrs.Color)
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// JSONNode is a node of the json adjacency list
+type JSONNode struct {
+	ID    string   `json:"id"`
+	Label string   `json:"label"`
+	Color string   `json:"color,omitempty"`
+	Nexts []string `json:"nexts"`
+}
+
+// WriteJSON writes the dag as a json adjacency list
+func (d *Dag) WriteJSON(w io.Writer, opts ExportOptions) error {
+	nodes := d.exportNodes(opts)
+	list := make([]JSONNode, len(nodes))
+	for i, n := range nodes {
+		list[i] = JSONNode{
+			ID:    n.attrs.ID,
+			Label: n.attrs.Label,
+			Color: n.attrs.Color,
+			Nexts: make([]string, len(n.nexts)),
+		}
+		for j, next := range n.nexts {
+			list[i].Nexts[j] = nodes[next].attrs.ID
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(list)
+}
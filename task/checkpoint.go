@@ -0,0 +1,136 @@
+package task
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RunState is the checkpoint of a run, it's saved after each task finishes
+type RunState struct {
+	RunID string               `json:"runId"`
+	Tasks map[string]TaskState `json:"tasks"`
+	// outputs of succeeded tasks, they're needed by downstream tasks on resume
+	Outputs map[string]map[string]string `json:"outputs,omitempty"`
+	Updated time.Time                    `json:"updated"`
+}
+
+// StateStore saves run states so that a failed run can be resumed
+type StateStore interface {
+	// Load returns nil if the run is not found
+	Load(runID string) (*RunState, error)
+	Save(state *RunState) error
+}
+
+// FileStateStore saves each run as a json file in a directory
+type FileStateStore struct {
+	dir string
+}
+
+func NewFileStateStore(dir string) (*FileStateStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FileStateStore{dir: dir}, nil
+}
+
+var patRunID = regexp.MustCompile(`^[\w.-]+$`)
+
+func (s *FileStateStore) path(runID string) (string, error) {
+	if !patRunID.MatchString(runID) {
+		return "", errors.Errorf("bad run id %q", runID)
+	}
+	return filepath.Join(s.dir, runID+".json"), nil
+}
+
+func (s *FileStateStore) Load(runID string) (*RunState, error) {
+	path, err := s.path(runID)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	state := &RunState{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, errors.Wrapf(err, "bad run state %s", path)
+	}
+	return state, nil
+}
+
+func (s *FileStateStore) Save(state *RunState) error {
+	path, err := s.path(state.RunID)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	// write to a temp file and rename so that a crash won't leave half a file
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// SetStateStore makes the dag save task states of runs to store, use SetRunID
+// to choose the run to resume
+func (d *dagTask) SetStateStore(store StateStore) {
+	d.store = store
+}
+
+func (d *dagTask) loadRunState(tasks []*task, resume bool) error {
+	if d.store == nil {
+		return nil
+	}
+	d.runState = &RunState{
+		RunID:   d.vars.runID,
+		Tasks:   map[string]TaskState{},
+		Outputs: map[string]map[string]string{},
+	}
+	if !resume {
+		return nil
+	}
+	saved, err := d.store.Load(d.runID)
+	if err != nil {
+		return err
+	}
+	if saved == nil {
+		return errors.Errorf("run %s not found", d.runID)
+	}
+	for _, t := range tasks {
+		if saved.Tasks[t.Name()] == StateSucceeded {
+			t.restore(saved.Outputs[t.Name()])
+			d.runState.Tasks[t.Name()] = StateSucceeded
+			d.runState.Outputs[t.Name()] = saved.Outputs[t.Name()]
+		}
+	}
+	return nil
+}
+
+func (d *dagTask) saveTaskState(t *task) {
+	if d.store == nil {
+		return
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.runState.Tasks[t.Name()] = t.state
+	if outputs := t.copyOutputs(); len(outputs) > 0 {
+		d.runState.Outputs[t.Name()] = outputs
+	}
+	d.runState.Updated = time.Now()
+	if err := d.store.Save(d.runState); err != nil {
+		d.logger.Warn("save run state failed", F("run", d.runState.RunID), F("error", err))
+	}
+}
@@ -0,0 +1,56 @@
+package task
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func TestResume(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileStateStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// task b fails until the flag file is created
+	d, err := CreateTaskDag(DagTaskConfig{
+		Tasks: []map[string]interface{}{
+			{"name": "a", "type": "sh", "shellcmd": "echo a >> runs", "shellcwd": dir},
+			{"name": "b", "type": "sh", "shellcmd": "echo b >> runs; test -f flag", "shellcwd": dir,
+				"dependOn": []interface{}{"a"}},
+			{"name": "c", "type": "sh", "shellcmd": "echo c >> runs", "shellcwd": dir,
+				"dependOn": []interface{}{"b"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.SetStateStore(store)
+	d.SetRunID("run1")
+	if err := d.Run(); err == nil {
+		t.Fatal("task b should fail")
+	}
+	state, err := store.Load("run1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Tasks["a"] != StateSucceeded || state.Tasks["b"] != StateFailed || state.Tasks["c"] != StateUpstreamFailed {
+		t.Fatalf("unexpected saved state %v", state.Tasks)
+	}
+
+	ioutil.WriteFile(dir+"/flag", nil, 0644)
+	if err := d.Resume(); err != nil {
+		t.Fatal(err)
+	}
+	runs, _ := ioutil.ReadFile(dir + "/runs")
+	if string(runs) != "a\nb\nb\nc\n" {
+		t.Fatalf("unexpected runs %q", runs)
+	}
+	if r := d.Result(); !r.Succeeded || !r.Tasks[0].Restored || r.Tasks[1].Restored {
+		t.Fatalf("unexpected result %v", r)
+	}
+
+	d.SetRunID("not-exist")
+	if err := d.Resume(); err == nil {
+		t.Fatal("should fail to resume unknown run")
+	}
+}
@@ -0,0 +1,452 @@
+// gotask runs the task dag described in a workflow file
+//
+//	gotask run [flags] workflow.yaml
+//	gotask list workflow.yaml
+//	gotask validate workflow.yaml
+//	gotask graph [-format dot|mermaid|json] [-state-dir dir -run-id id] workflow.yaml
+//	gotask schedule [-state-dir dir] workflow.yaml...
+//	gotask schema [type]
+//
+// It exits with 1 if any task fails and 2 if the workflow is invalid.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/zxdvd/go-libs/task"
+)
+
+const (
+	exitOK = iota
+	exitFailed
+	exitInvalid
+)
+
+// environment variables like GOTASK_PARAM_KEY=value are params of the run
+const envParamPrefix = "GOTASK_PARAM_"
+
+const usage = `usage: gotask <command> [flags] <workflow file>
+
+commands:
+  run        run all tasks, see gotask run -h for flags
+  list       list tasks with their types and dependencies
+  validate   check the workflow file
+  graph      print task dependencies, see gotask graph -h for flags
+  schedule   run workflows by their schedules until interrupted, more
+             than one workflow file can be given
+  schema     print the JSON Schema of configs of a task type, or list
+             task types without the type
+`
+
+// paramFlag collects -param key=value flags
+type paramFlag map[string]string
+
+func (p paramFlag) String() string {
+	return fmt.Sprint(map[string]string(p))
+}
+
+func (p paramFlag) Set(s string) error {
+	kv := strings.SplitN(s, "=", 2)
+	if len(kv) != 2 {
+		return fmt.Errorf("param should be key=value, got %s", s)
+	}
+	p[kv[0]] = kv[1]
+	return nil
+}
+
+type runFlags struct {
+	task       string
+	mode       string
+	report     string
+	stateDir   string
+	runID      string
+	resume     bool
+	date       string
+	paramsFile string
+	params     paramFlag
+	dryRun     bool
+	format     string
+	outputDir  string
+	quiet      bool
+	metrics    string
+	// name of the workflow, the base name of the file
+	name string
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return exitInvalid
+	}
+	cmd, args := args[0], args[1:]
+	fs := flag.NewFlagSet(cmd, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	rf := runFlags{params: paramFlag{}}
+	if cmd == "run" {
+		fs.StringVar(&rf.task, "task", "", "run only this task and its dependencies")
+		fs.StringVar(&rf.mode, "mode", "", "fail-fast or continue, override the mode of workflow file")
+		fs.StringVar(&rf.report, "report", "", "write the run result as json to this file")
+		fs.StringVar(&rf.stateDir, "state-dir", "", "save task states to this directory so that the run can be resumed")
+		fs.StringVar(&rf.runID, "run-id", "", "id of the run, generated if not set")
+		fs.BoolVar(&rf.resume, "resume", false, "resume the run of -run-id, skip tasks succeeded in it")
+		fs.StringVar(&rf.date, "date", "", "date of the run as YYYY-MM-DD, today by default")
+		fs.StringVar(&rf.paramsFile, "params", "", "read params from this yaml, json or toml file")
+		fs.Var(rf.params, "param", "param of the run as key=value, can be repeated")
+		fs.BoolVar(&rf.dryRun, "dry-run", false, "validate task configs and print the execution plan without running")
+		fs.StringVar(&rf.outputDir, "output-dir", "", "write output of each task to TASK.log in this directory instead of stdout")
+		fs.BoolVar(&rf.quiet, "quiet", false, "don't log task events to stderr")
+		fs.StringVar(&rf.metrics, "metrics-file", "", "write metrics of the run to this file for the textfile collector of node exporter")
+	}
+	if cmd == "graph" {
+		fs.StringVar(&rf.format, "format", "", "dot, mermaid or json, plain edges by default")
+		fs.StringVar(&rf.stateDir, "state-dir", "", "color tasks by states of -run-id saved in this directory")
+		fs.StringVar(&rf.runID, "run-id", "", "id of the run to color tasks by")
+	}
+	if cmd == "schedule" {
+		fs.StringVar(&rf.stateDir, "state-dir", "", "save run states and last scheduled times to this directory, needed by catch-up")
+		fs.StringVar(&rf.metrics, "metrics-addr", "", "serve Prometheus metrics at /metrics of this address, like :9100")
+	}
+	if err := fs.Parse(args); err != nil {
+		return exitInvalid
+	}
+	if cmd == "schedule" && fs.NArg() > 0 {
+		return schedule(fs.Args(), rf, stderr)
+	}
+	if cmd == "schema" && fs.NArg() <= 1 {
+		return schema(fs.Arg(0), stdout, stderr)
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprint(stderr, usage)
+		return exitInvalid
+	}
+	path := fs.Arg(0)
+	rf.name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+
+	switch cmd {
+	case "run", "list", "validate", "graph":
+	default:
+		fmt.Fprintf(stderr, "unknown command %s\n\n%s", cmd, usage)
+		return exitInvalid
+	}
+	d, err := task.LoadWorkflow(path)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitInvalid
+	}
+
+	switch cmd {
+	case "run":
+		return runDag(d, rf, stdout, stderr)
+	case "list":
+		w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tTYPE\tDEPEND ON")
+		for _, info := range d.TaskInfos() {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", info.Name, info.Type, strings.Join(info.DependOn, ","))
+		}
+		w.Flush()
+	case "validate":
+		fmt.Fprintf(stdout, "%s: ok, %d tasks\n", path, len(d.Nodes()))
+	case "graph":
+		return graph(d, rf, stdout, stderr)
+	}
+	return exitOK
+}
+
+func schema(typ string, stdout, stderr io.Writer) int {
+	if typ == "" {
+		for _, typ := range task.Types() {
+			fmt.Fprintln(stdout, typ)
+		}
+		return exitOK
+	}
+	s, err := task.Schema(typ)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitInvalid
+	}
+	enc := json.NewEncoder(stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(s)
+	return exitOK
+}
+
+func graph(d dagTask, rf runFlags, stdout, stderr io.Writer) int {
+	if rf.format == "" {
+		for _, info := range d.TaskInfos() {
+			if len(info.DependOn) == 0 {
+				fmt.Fprintln(stdout, info.Name)
+			}
+			for _, dep := range info.DependOn {
+				fmt.Fprintf(stdout, "%s -> %s\n", dep, info.Name)
+			}
+		}
+		return exitOK
+	}
+	var states map[string]task.TaskState
+	if rf.stateDir != "" || rf.runID != "" {
+		if rf.stateDir == "" || rf.runID == "" {
+			fmt.Fprintln(stderr, "-state-dir and -run-id should be used together")
+			return exitInvalid
+		}
+		store, err := task.NewFileStateStore(rf.stateDir)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return exitInvalid
+		}
+		state, err := store.Load(rf.runID)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return exitInvalid
+		}
+		if state == nil {
+			fmt.Fprintf(stderr, "run %s not found\n", rf.runID)
+			return exitInvalid
+		}
+		states = state.Tasks
+	}
+	if err := d.WriteGraph(stdout, task.GraphFormat(rf.format), states); err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitInvalid
+	}
+	return exitOK
+}
+
+// dagTask is what commands need from the dag returned by task.LoadWorkflow
+type dagTask interface {
+	SetMode(task.RunMode) error
+	SetStateStore(task.StateStore)
+	SetRunID(string)
+	SetRunDate(time.Time)
+	SetParams(map[string]string)
+	SetLogger(task.Logger)
+	SetOutputSink(task.OutputSink)
+	SetMetrics(*task.Metrics, string)
+	RunContext(context.Context) error
+	RunTaskContext(context.Context, string) error
+	ResumeContext(context.Context) error
+	Result() *task.RunResult
+	Plan() (*task.Plan, error)
+	PlanTask(string) (*task.Plan, error)
+	TaskInfos() []task.TaskInfo
+	WriteGraph(io.Writer, task.GraphFormat, map[string]task.TaskState) error
+}
+
+func runDag(d dagTask, rf runFlags, stdout, stderr io.Writer) int {
+	if rf.mode != "" {
+		if err := d.SetMode(task.RunMode(rf.mode)); err != nil {
+			fmt.Fprintln(stderr, err)
+			return exitInvalid
+		}
+	}
+	if rf.resume && (rf.stateDir == "" || rf.runID == "") {
+		fmt.Fprintln(stderr, "-resume needs -state-dir and -run-id")
+		return exitInvalid
+	}
+	if rf.resume && rf.task != "" {
+		fmt.Fprintln(stderr, "-resume and -task can't be used together")
+		return exitInvalid
+	}
+	if rf.task != "" && !hasTask(d, rf.task) {
+		fmt.Fprintf(stderr, "task %s not found\n", rf.task)
+		return exitInvalid
+	}
+	if rf.stateDir != "" {
+		store, err := task.NewFileStateStore(rf.stateDir)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return exitInvalid
+		}
+		d.SetStateStore(store)
+	}
+	if rf.runID != "" {
+		d.SetRunID(rf.runID)
+	}
+	if rf.outputDir != "" {
+		sink, err := task.NewFileSink(rf.outputDir)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return exitInvalid
+		}
+		d.SetOutputSink(sink)
+	}
+	if rf.quiet {
+		d.SetLogger(task.NopLogger())
+	}
+	if rf.date != "" {
+		date, err := time.ParseInLocation("2006-01-02", rf.date, time.Local)
+		if err != nil {
+			fmt.Fprintln(stderr, "bad -date:", err)
+			return exitInvalid
+		}
+		d.SetRunDate(date)
+	}
+	// params from file, then env, then flags, the latter overrides
+	if rf.paramsFile != "" {
+		params, err := task.LoadParams(rf.paramsFile)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return exitInvalid
+		}
+		d.SetParams(params)
+	}
+	envParams := map[string]string{}
+	for _, env := range os.Environ() {
+		if strings.HasPrefix(env, envParamPrefix) {
+			kv := strings.SplitN(env[len(envParamPrefix):], "=", 2)
+			envParams[kv[0]] = kv[1]
+		}
+	}
+	d.SetParams(envParams)
+	d.SetParams(rf.params)
+
+	if rf.dryRun {
+		return planDag(d, rf, stdout, stderr)
+	}
+	var metrics *task.Metrics
+	if rf.metrics != "" {
+		metrics = task.NewMetrics()
+		d.SetMetrics(metrics, rf.name)
+	}
+	// running tasks are cancelled on signals, which kills their processes
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	var err error
+	switch {
+	case rf.task != "":
+		err = d.RunTaskContext(ctx, rf.task)
+	case rf.resume:
+		err = d.ResumeContext(ctx)
+	default:
+		err = d.RunContext(ctx)
+	}
+	result := d.Result()
+	if result.RunID != "" {
+		fmt.Fprintln(stderr, "run id:", result.RunID)
+	}
+	fmt.Fprintln(stdout)
+	result.WriteTable(stdout)
+	if rf.report != "" {
+		if werr := writeReport(rf.report, result); werr != nil {
+			fmt.Fprintln(stderr, "write report failed:", werr)
+		}
+	}
+	if metrics != nil {
+		if werr := metrics.WriteTextfile(rf.metrics); werr != nil {
+			fmt.Fprintln(stderr, "write metrics failed:", werr)
+		}
+	}
+	if err == nil && ctx.Err() != nil {
+		err = errors.New("interrupted")
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, "run failed:", err)
+		return exitFailed
+	}
+	return exitOK
+}
+
+func hasTask(d dagTask, name string) bool {
+	for _, info := range d.TaskInfos() {
+		if info.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func schedule(paths []string, rf runFlags, stderr io.Writer) int {
+	s := task.NewScheduler()
+	if rf.stateDir != "" {
+		if err := s.SetStateDir(rf.stateDir); err != nil {
+			fmt.Fprintln(stderr, err)
+			return exitInvalid
+		}
+	}
+	for _, path := range paths {
+		if err := s.AddWorkflow(path); err != nil {
+			fmt.Fprintln(stderr, err)
+			return exitInvalid
+		}
+	}
+	// running tasks are cancelled on signals, a second signal exits at once
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if rf.metrics != "" {
+		metrics := task.NewMetrics()
+		s.SetMetrics(metrics)
+		go func() {
+			if err := metrics.ListenAndServe(ctx, rf.metrics); err != nil {
+				fmt.Fprintln(stderr, "serve metrics failed:", err)
+			}
+		}()
+	}
+	s.OnFinish(func(name string, result *task.RunResult, err error) {
+		if err != nil {
+			fmt.Fprintf(stderr, "%s failed: %v\n", name, err)
+			return
+		}
+		fmt.Fprintf(stderr, "%s succeeded, run id: %s\n", name, result.RunID)
+	})
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			// restore the default behavior of signals
+			stop()
+			fmt.Fprintln(stderr, "stopping, waiting for running workflows")
+		case <-done:
+		}
+	}()
+	if err := s.Run(ctx); err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitInvalid
+	}
+	return exitOK
+}
+
+func planDag(d dagTask, rf runFlags, stdout, stderr io.Writer) int {
+	var (
+		plan *task.Plan
+		err  error
+	)
+	if rf.task != "" {
+		plan, err = d.PlanTask(rf.task)
+	} else {
+		plan, err = d.Plan()
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, "plan failed:", err)
+		return exitInvalid
+	}
+	plan.WriteText(stdout)
+	return exitOK
+}
+
+func writeReport(path string, result *task.RunResult) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := result.WriteJSON(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const workflow = `
+tasks:
+  - name: a
+    type: echo
+    echostr: hello
+  - name: b
+    type: sh
+    shellcmd: exit 3
+    dependOn: [a]
+`
+
+// writeWorkflow writes the workflow to wf.yaml of a temporary directory
+func writeWorkflow(t *testing.T) (dir, path string) {
+	dir = t.TempDir()
+	path = filepath.Join(dir, "wf.yaml")
+	if err := ioutil.WriteFile(path, []byte(workflow), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return dir, path
+}
+
+type cliCase struct {
+	args   []string
+	code   int
+	output string
+}
+
+func runCases(t *testing.T, cases []cliCase) {
+	for _, c := range cases {
+		var stdout, stderr bytes.Buffer
+		code := run(c.args, &stdout, &stderr)
+		if code != c.code {
+			t.Fatalf("%v: expect exit code %d, got %d, %s", c.args, c.code, code, stderr.String())
+		}
+		if !strings.Contains(stdout.String(), c.output) {
+			t.Fatalf("%v: unexpected output %q", c.args, stdout.String())
+		}
+	}
+}
+
+func TestUsage(t *testing.T) {
+	_, path := writeWorkflow(t)
+	runCases(t, []cliCase{
+		{nil, exitInvalid, ""},
+		{[]string{"unknown", path}, exitInvalid, ""},
+		{[]string{"validate"}, exitInvalid, ""},
+	})
+}
+
+func TestValidate(t *testing.T) {
+	dir, path := writeWorkflow(t)
+	runCases(t, []cliCase{
+		{[]string{"validate", path}, exitOK, "ok, 2 tasks"},
+		{[]string{"validate", filepath.Join(dir, "missing.yaml")}, exitInvalid, ""},
+	})
+}
+
+func TestList(t *testing.T) {
+	_, path := writeWorkflow(t)
+	runCases(t, []cliCase{
+		{[]string{"list", path}, exitOK, "b     sh    a"},
+	})
+}
+
+func TestGraph(t *testing.T) {
+	_, path := writeWorkflow(t)
+	runCases(t, []cliCase{
+		{[]string{"graph", path}, exitOK, "a -> b"},
+		{[]string{"graph", "-format", "dot", path}, exitOK, `"a" -> "b";`},
+		{[]string{"graph", "-format", "svg", path}, exitInvalid, ""},
+		{[]string{"graph", "-format", "dot", "-run-id", "x", path}, exitInvalid, ""},
+	})
+}
+
+func TestRun(t *testing.T) {
+	dir, path := writeWorkflow(t)
+	runCases(t, []cliCase{
+		{[]string{"run", "-task", "a", path}, exitOK, ""},
+		{[]string{"run", "-task", "c", path}, exitInvalid, ""},
+		{[]string{"run", "-resume", "-task", "a", "-state-dir", dir, "-run-id", "x", path}, exitInvalid, ""},
+		{[]string{"run", "-resume", path}, exitInvalid, ""},
+		{[]string{"run", "-mode", "unknown", path}, exitInvalid, ""},
+		{[]string{"run", "-report", filepath.Join(dir, "report.json"), path}, exitFailed, "failed"},
+		{[]string{"run", filepath.Join(dir, "missing.yaml")}, exitInvalid, ""},
+	})
+	data, err := ioutil.ReadFile(filepath.Join(dir, "report.json"))
+	if err != nil || !strings.Contains(string(data), `"state": "failed"`) {
+		t.Fatalf("bad report %s, %v", data, err)
+	}
+}
+
+func TestRunDryRun(t *testing.T) {
+	_, path := writeWorkflow(t)
+	runCases(t, []cliCase{
+		{[]string{"run", "-dry-run", path}, exitOK, "wave 2:\n  b (sh) after [a]"},
+		{[]string{"run", "-dry-run", "-task", "c", path}, exitInvalid, ""},
+	})
+}
+
+func TestRunOutputDir(t *testing.T) {
+	dir, path := writeWorkflow(t)
+	runCases(t, []cliCase{
+		{[]string{"run", "-quiet", "-output-dir", filepath.Join(dir, "logs"), path}, exitFailed, ""},
+	})
+	if data, err := ioutil.ReadFile(filepath.Join(dir, "logs", "a.log")); err != nil || string(data) != "hello\n" {
+		t.Fatalf("bad output file %q, %v", data, err)
+	}
+}
+
+func TestRunMetrics(t *testing.T) {
+	dir, path := writeWorkflow(t)
+	runCases(t, []cliCase{
+		{[]string{"run", "-quiet", "-metrics-file", filepath.Join(dir, "metrics.prom"), path}, exitFailed, ""},
+	})
+	data, err := ioutil.ReadFile(filepath.Join(dir, "metrics.prom"))
+	if err != nil || !strings.Contains(string(data), `gotask_tasks_total{dag="wf",task="b",type="sh",result="failed"} 1`) {
+		t.Fatalf("bad metrics %s, %v", data, err)
+	}
+}
+
+func TestSchema(t *testing.T) {
+	runCases(t, []cliCase{
+		{[]string{"schema"}, exitOK, "echo\nhttp\n"},
+		{[]string{"schema", "sh"}, exitOK, `"shellcmd"`},
+		{[]string{"schema", "unknown"}, exitInvalid, ""},
+	})
+}
@@ -0,0 +1,449 @@
+package task
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Task types registered by RegisterConfig have a typed config, a pointer to
+// a struct whose fields are decoded from the config map by tags like
+//
+//	type ShellConfig struct {
+//		Shellcmd string `task:"shellcmd,required" desc:"command run by sh -c"`
+//		Shell    string `task:"shell" default:"sh" enum:"sh|bash"`
+//	}
+//
+// Supported field types are string, bool, int, float64, time.Duration,
+// []string, []int, map[string]string, []interface{} and interface{}, and
+// embedded structs of fields. A default is used if the field is missing, enum
+// lists allowed values of strings split by |. Unknown fields are errors.
+
+// FieldError is an invalid field of a task config
+type FieldError struct {
+	Field string
+	Err   error
+}
+
+func (e *FieldError) Error() string {
+	if e.Field == "" {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%s: %v", e.Field, e.Err)
+}
+
+// ConfigError collects all invalid fields of a task config
+type ConfigError struct {
+	Task   string
+	Fields []*FieldError
+}
+
+func (e *ConfigError) Error() string {
+	msgs := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		msgs[i] = f.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (e *ConfigError) add(field string, err error) {
+	if err == nil {
+		return
+	}
+	if ce, ok := err.(*ConfigError); ok {
+		e.Fields = append(e.Fields, ce.Fields...)
+		return
+	}
+	e.Fields = append(e.Fields, &FieldError{Field: field, Err: err})
+}
+
+func (e *ConfigError) err() error {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+	return e
+}
+
+// commonConfig lists fields handled by NewTask for tasks of all types, it's
+// used to find unknown fields and to generate schemas
+type commonConfig struct {
+	Name             string        `task:"name,required" desc:"unique name of the task"`
+	Type             string        `task:"type,required" desc:"type of the task"`
+	DependOn         []string      `task:"dependOn" desc:"tasks to run before it"`
+	Timeout          time.Duration `task:"timeout" desc:"timeout of each attempt"`
+	TriggerRule      string        `task:"triggerRule" default:"all_success" enum:"all_success|all_done|one_failed|one_success|none_failed" desc:"when to run according to states of depended tasks"`
+	When             string        `task:"when" desc:"run only if the expression is true"`
+	MapOver          interface{}   `task:"mapOver" desc:"run an instance for each item of the list"`
+	Pools            interface{}   `task:"pools" desc:"a pool name, a list of names, or a mapping of names to numbers of slots"`
+	Priority         int           `task:"priority" desc:"tasks of higher priority start first when there are not enough slots"`
+	Retries          int           `task:"retries" desc:"max retry times"`
+	RetryDelay       time.Duration `task:"retryDelay" desc:"delay before the first retry"`
+	RetryBackoff     float64       `task:"retryBackoff" default:"1" desc:"multiply the delay by it for each retry"`
+	RetryMaxDelay    time.Duration `task:"retryMaxDelay" desc:"upper limit of the retry delay"`
+	RetryJitter      float64       `task:"retryJitter" desc:"randomize the retry delay by +-jitter"`
+	RetryOnExitCodes []int         `task:"retryOnExitCodes" desc:"only retry on these exit codes"`
+	RetryOnErrors    []string      `task:"retryOnErrors" desc:"only retry if the error matches one of the regexps"`
+}
+
+var commonFields = configFields(reflect.TypeOf(commonConfig{}))
+
+// configField is a tagged field of a config struct
+type configField struct {
+	key      string
+	required bool
+	index    []int
+	field    reflect.StructField
+}
+
+func configFields(t reflect.Type) []configField {
+	var fields []configField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			for _, sub := range configFields(f.Type) {
+				sub.index = append([]int{i}, sub.index...)
+				fields = append(fields, sub)
+			}
+			continue
+		}
+		tag := f.Tag.Get("task")
+		if tag == "" {
+			continue
+		}
+		parts := strings.Split(tag, ",")
+		fields = append(fields, configField{
+			key:      parts[0],
+			required: len(parts) > 1 && parts[1] == "required",
+			index:    []int{i},
+			field:    f,
+		})
+	}
+	return fields
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func checkFieldType(t reflect.Type) error {
+	if t == durationType {
+		return nil
+	}
+	switch t.Kind() {
+	case reflect.String, reflect.Bool, reflect.Int, reflect.Float64, reflect.Interface:
+		return nil
+	case reflect.Slice:
+		switch t.Elem().Kind() {
+		case reflect.String, reflect.Int, reflect.Interface:
+			return nil
+		}
+	case reflect.Map:
+		if t.Key().Kind() == reflect.String && t.Elem().Kind() == reflect.String {
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported config field type %s", t)
+}
+
+// decodeConfig sets fields of the struct pointed by ptr from conf
+func decodeConfig(conf map[string]interface{}, ptr interface{}, errs *ConfigError) {
+	v := reflect.ValueOf(ptr).Elem()
+	for _, f := range configFields(v.Type()) {
+		raw, ok := conf[f.key]
+		if !ok || raw == nil {
+			def, ok := f.field.Tag.Lookup("default")
+			if !ok {
+				if f.required {
+					errs.add(f.key, errors.New("missing"))
+				}
+				continue
+			}
+			raw = def
+		}
+		if err := setField(v.FieldByIndex(f.index), raw); err != nil {
+			errs.add(f.key, err)
+			continue
+		}
+		if enum := f.field.Tag.Get("enum"); enum != "" {
+			s := v.FieldByIndex(f.index).String()
+			if !contains(strings.Split(enum, "|"), s) {
+				errs.add(f.key, fmt.Errorf("expect one of %s, got %q", strings.Replace(enum, "|", ", ", -1), s))
+			}
+		}
+	}
+}
+
+func setField(fv reflect.Value, raw interface{}) error {
+	if fv.Type() == durationType {
+		d, err := toDuration(raw)
+		if err != nil {
+			return err
+		}
+		fv.SetInt(int64(d))
+		return nil
+	}
+	// defaults are strings
+	s, isString := raw.(string)
+	switch fv.Kind() {
+	case reflect.String:
+		if !isString {
+			return fmt.Errorf("expect a string, got %v", raw)
+		}
+		fv.SetString(s)
+	case reflect.Bool:
+		b, ok := raw.(bool)
+		if isString {
+			var err error
+			b, err = strconv.ParseBool(s)
+			ok = err == nil
+		}
+		if !ok {
+			return fmt.Errorf("expect a boolean, got %v", raw)
+		}
+		fv.SetBool(b)
+	case reflect.Int:
+		n, ok := toInt(raw)
+		if isString {
+			var err error
+			n, err = strconv.Atoi(s)
+			ok = err == nil
+		}
+		if !ok {
+			return fmt.Errorf("expect an integer, got %v", raw)
+		}
+		fv.SetInt(int64(n))
+	case reflect.Float64:
+		f, ok := toFloat(raw)
+		if isString {
+			var err error
+			f, err = strconv.ParseFloat(s, 64)
+			ok = err == nil
+		}
+		if !ok {
+			return fmt.Errorf("expect a number, got %v", raw)
+		}
+		fv.SetFloat(f)
+	case reflect.Interface:
+		fv.Set(reflect.ValueOf(raw))
+	case reflect.Slice:
+		return setSlice(fv, raw)
+	case reflect.Map:
+		m := map[string]string{}
+		if err := toStringMap(raw, m); err != nil {
+			return err
+		}
+		fv.Set(reflect.ValueOf(m))
+	}
+	return nil
+}
+
+func setSlice(fv reflect.Value, raw interface{}) error {
+	switch fv.Type().Elem().Kind() {
+	case reflect.String:
+		list, err := toStringList(raw)
+		if err != nil {
+			return err
+		}
+		strs := make([]string, len(list))
+		for i, item := range list {
+			strs[i] = item.(string)
+		}
+		fv.Set(reflect.ValueOf(strs))
+	case reflect.Int:
+		list, ok := raw.([]interface{})
+		if !ok {
+			list = []interface{}{raw}
+		}
+		// defaults are like "200,201"
+		if s, ok := raw.(string); ok {
+			list = nil
+			for _, item := range strings.Split(s, ",") {
+				if n, err := strconv.Atoi(strings.TrimSpace(item)); err == nil {
+					list = append(list, n)
+				} else {
+					list = append(list, item)
+				}
+			}
+		}
+		ints := make([]int, len(list))
+		for i, item := range list {
+			if ints[i], ok = toInt(item); !ok {
+				return fmt.Errorf("expect an integer or a list of integers, got %v", raw)
+			}
+		}
+		fv.Set(reflect.ValueOf(ints))
+	case reflect.Interface:
+		list, ok := raw.([]interface{})
+		if !ok {
+			return fmt.Errorf("expect a list, got %v", raw)
+		}
+		fv.Set(reflect.ValueOf(list))
+	}
+	return nil
+}
+
+// typedTask is a task type registered by RegisterConfig
+type typedTask struct {
+	config reflect.Type
+	new    func(name string, config interface{}) (Task, error)
+}
+
+// RegisterConfig registers a task type with a typed config. config is a
+// pointer to a zero config struct, new is called with the task name and a
+// pointer to a new config decoded from the config map.
+func RegisterConfig(typ string, config interface{}, new func(name string, config interface{}) (Task, error)) error {
+	t := reflect.TypeOf(config)
+	if t == nil || t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("config of type %s should be a pointer to struct", typ)
+	}
+	for _, f := range configFields(t.Elem()) {
+		if err := checkFieldType(f.field.Type); err != nil {
+			return errors.Wrapf(err, "field %s of type %s", f.key, typ)
+		}
+	}
+	registeredTasks.lock.Lock()
+	defer registeredTasks.lock.Unlock()
+	if isRegistered(typ) {
+		return fmt.Errorf("type %s registered!", typ)
+	}
+	registeredTasks.typed[typ] = &typedTask{config: t.Elem(), new: new}
+	return nil
+}
+
+func (tt *typedTask) create(conf map[string]interface{}) (Task, error) {
+	name, _ := conf["name"].(string)
+	errs := &ConfigError{Task: name}
+	if name == "" {
+		errs.add("name", errors.New("missing"))
+	}
+	known := map[string]bool{}
+	for _, fields := range [][]configField{commonFields, configFields(tt.config)} {
+		for _, f := range fields {
+			known[f.key] = true
+		}
+	}
+	keys := make([]string, 0, len(conf))
+	for k := range conf {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if !known[k] {
+			errs.add(k, errors.New("unknown field"))
+		}
+	}
+	ptr := reflect.New(tt.config)
+	decodeConfig(conf, ptr.Interface(), errs)
+	if err := errs.err(); err != nil {
+		return nil, err
+	}
+	return tt.new(name, ptr.Interface())
+}
+
+// isRegistered should be called with registeredTasks locked
+func isRegistered(typ string) bool {
+	_, ok := registeredTasks.constructors[typ]
+	_, typed := registeredTasks.typed[typ]
+	return ok || typed
+}
+
+// Types returns all registered task types
+func Types() []string {
+	registeredTasks.lock.Lock()
+	defer registeredTasks.lock.Unlock()
+	var types []string
+	for typ := range registeredTasks.constructors {
+		types = append(types, typ)
+	}
+	for typ := range registeredTasks.typed {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// Schema returns the JSON Schema of configs of a task type registered by
+// RegisterConfig
+func Schema(typ string) (map[string]interface{}, error) {
+	registeredTasks.lock.Lock()
+	tt, ok := registeredTasks.typed[typ]
+	registeredTasks.lock.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("type %s has no typed config", typ)
+	}
+	props := map[string]interface{}{}
+	required := []string{}
+	for _, fields := range [][]configField{commonFields, configFields(tt.config)} {
+		for _, f := range fields {
+			// internal fields
+			if strings.HasPrefix(f.key, "_") {
+				continue
+			}
+			props[f.key] = fieldSchema(f)
+			if f.required {
+				required = append(required, f.key)
+			}
+		}
+	}
+	props["type"] = map[string]interface{}{"const": typ}
+	return map[string]interface{}{
+		"$schema":              "http://json-schema.org/draft-07/schema#",
+		"title":                typ + " task",
+		"type":                 "object",
+		"properties":           props,
+		"required":             required,
+		"additionalProperties": false,
+	}, nil
+}
+
+func fieldSchema(f configField) map[string]interface{} {
+	s := map[string]interface{}{}
+	t := f.field.Type
+	switch {
+	case t == durationType:
+		s["type"] = []string{"string", "number"}
+		s["description"] = "duration like 1m30s or seconds"
+	case t.Kind() == reflect.String:
+		s["type"] = "string"
+	case t.Kind() == reflect.Bool:
+		s["type"] = "boolean"
+	case t.Kind() == reflect.Int:
+		s["type"] = "integer"
+	case t.Kind() == reflect.Float64:
+		s["type"] = "number"
+	case t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.String:
+		s["type"] = []string{"string", "array"}
+		s["items"] = map[string]interface{}{"type": "string"}
+	case t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Int:
+		s["type"] = []string{"integer", "array"}
+		s["items"] = map[string]interface{}{"type": "integer"}
+	case t.Kind() == reflect.Slice:
+		s["type"] = "array"
+	case t.Kind() == reflect.Map:
+		s["type"] = "object"
+		s["additionalProperties"] = map[string]interface{}{"type": []string{"string", "number", "boolean"}}
+	}
+	if desc := f.field.Tag.Get("desc"); desc != "" {
+		if d, ok := s["description"]; ok {
+			desc += ", " + d.(string)
+		}
+		s["description"] = desc
+	}
+	if enum := f.field.Tag.Get("enum"); enum != "" {
+		s["enum"] = strings.Split(enum, "|")
+	}
+	if def, ok := f.field.Tag.Lookup("default"); ok {
+		v := reflect.New(t).Elem()
+		if setField(v, def) == nil {
+			if t == durationType {
+				s["default"] = def
+			} else {
+				s["default"] = v.Interface()
+			}
+		}
+	}
+	return s
+}
@@ -0,0 +1,128 @@
+package task
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewTaskConfigErrors(t *testing.T) {
+	_, err := NewTask("http", map[string]interface{}{
+		"name":         "get",
+		"type":         "http",
+		"expectStatus": "ok",
+		"timeout":      "soon",
+		"triggerRule":  "always",
+		"retryCount":   3,
+	})
+	e, ok := err.(*ConfigError)
+	if !ok {
+		t.Fatalf("expect config error, got %v", err)
+	}
+	var fields []string
+	for _, f := range e.Fields {
+		fields = append(fields, f.Field)
+	}
+	if e.Task != "get" || strings.Join(fields, ",") != "retryCount,url,expectStatus,timeout,triggerRule" {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if !strings.HasPrefix(err.Error(), "retryCount: unknown field; url: missing; ") {
+		t.Fatalf("unexpected message %s", err)
+	}
+
+	// all invalid tasks are reported
+	_, err = CreateTaskDag(DagTaskConfig{
+		Tasks: []map[string]interface{}{
+			{"name": "a", "type": "echo"},
+			{"name": "b", "type": "echo", "echostr": "b"},
+			{"name": "c", "type": "sh", "shellcmd": 1},
+		},
+	})
+	errs, ok := err.(TaskConfigErrors)
+	if !ok || len(errs) != 2 || errs[0].Index != 0 || errs[1].Index != 2 {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if errs[1].Error() != "task c: shellcmd: expect a string, got 1" {
+		t.Fatalf("unexpected message %s", errs[1])
+	}
+
+	path := writeWorkflow(t, "wf.yaml", `
+tasks:
+  - name: a
+    type: echo
+  - name: b
+    type: wait
+    file: x
+    pollInterval: 0s
+`)
+	_, err = LoadWorkflow(path)
+	werrs, ok := err.(WorkflowErrors)
+	if !ok || len(werrs) != 2 || werrs[0].Line != 3 || werrs[1].Line != 5 || werrs[1].Task != "b" {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestTypedConfig(t *testing.T) {
+	impl, err := newTask("wait", map[string]interface{}{"name": "w", "url": "http://localhost"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := impl.(*WaitTask); w.interval != 30*time.Second {
+		t.Fatalf("unexpected pollInterval %s", w.interval)
+	}
+	impl, err = newTask("http", map[string]interface{}{"name": "h", "url": "http://localhost",
+		"expectStatus": []interface{}{200, 201}, "requestTimeout": 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := impl.(*HttpTask)
+	if h.method != "GET" || len(h.expectStatus) != 2 || h.client.Timeout != 5*time.Second {
+		t.Fatalf("unexpected task %+v", h)
+	}
+
+	// legacy constructors may panic on bad configs
+	Register("legacy", func(args ...interface{}) (Task, error) {
+		conf := args[0].(map[string]interface{})
+		return &EchoTask{str: conf["str"].(string)}, nil
+	})
+	if _, err := newTask("legacy", map[string]interface{}{}); err == nil {
+		t.Fatal("expect error of a panicking constructor")
+	}
+
+	type badConfig struct {
+		Ch chan int `task:"ch"`
+	}
+	if err := RegisterConfig("bad", &badConfig{}, nil); err == nil {
+		t.Fatal("expect error of unsupported field type")
+	}
+	if err := RegisterConfig("echo", &EchoConfig{}, newEchoTask); err == nil {
+		t.Fatal("expect error of registered type")
+	}
+}
+
+func TestSchema(t *testing.T) {
+	s, err := Schema("http")
+	if err != nil {
+		t.Fatal(err)
+	}
+	props := s["properties"].(map[string]interface{})
+	method := props["method"].(map[string]interface{})
+	if method["default"] != "GET" || method["type"] != "string" {
+		t.Fatalf("unexpected schema of method %v", method)
+	}
+	for _, key := range []string{"caFile", "retries", "dependOn"} {
+		if _, ok := props[key]; !ok {
+			t.Fatalf("%s not in schema", key)
+		}
+	}
+	if required := s["required"].([]string); strings.Join(required, ",") != "name,type,url" {
+		t.Fatalf("unexpected required %v", required)
+	}
+	s, _ = Schema("dag")
+	if _, ok := s["properties"].(map[string]interface{})[workflowStackKey]; ok {
+		t.Fatal("internal field in schema")
+	}
+	if _, err := Schema("unknown"); err == nil {
+		t.Fatal("expect error of unknown type")
+	}
+}
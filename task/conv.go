@@ -0,0 +1,80 @@
+package task
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// helpers to read values of task configs which may come from go code, yaml,
+// json or toml, numbers may be strings after variables are replaced
+
+func toInt(v interface{}) (int, bool) {
+	switch n := v.(type) {
+	case string:
+		i, err := strconv.Atoi(n)
+		return i, err == nil
+	case int:
+		return n, true
+	case int64:
+		return int(n), true
+	case float64:
+		if n == float64(int(n)) {
+			return int(n), true
+		}
+	}
+	return 0, false
+}
+
+// toStringList accepts a single string or a list of strings
+func toStringList(v interface{}) ([]interface{}, error) {
+	switch l := v.(type) {
+	case nil:
+		return []interface{}{}, nil
+	case string:
+		return []interface{}{l}, nil
+	case []interface{}:
+		for _, item := range l {
+			if _, ok := item.(string); !ok {
+				return nil, fmt.Errorf("expect string, got %v", item)
+			}
+		}
+		return l, nil
+	case []string:
+		list := make([]interface{}, len(l))
+		for i, s := range l {
+			list[i] = s
+		}
+		return list, nil
+	}
+	return nil, fmt.Errorf("expect a list of string, got %v", v)
+}
+
+// toDuration accepts a duration string like "1m30s" or a number of seconds
+func toDuration(v interface{}) (time.Duration, error) {
+	switch d := v.(type) {
+	case string:
+		return time.ParseDuration(d)
+	case time.Duration:
+		return d, nil
+	case int, int64, float64:
+		f, _ := toFloat(d)
+		return time.Duration(f * float64(time.Second)), nil
+	}
+	return 0, fmt.Errorf("expect a duration, got %v", v)
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case string:
+		f, err := strconv.ParseFloat(n, 64)
+		return f, err == nil
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
@@ -0,0 +1,296 @@
+package task
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// dispatcher runs selected tasks of a dag run. A task is ready once all tasks
+// it depends on finished, and ready tasks are started in order while pools
+// have free slots, see (*task).before. Only attempts of tasks run in their
+// own goroutines, states of tasks are changed by the dispatcher.
+type dispatcher struct {
+	d     *dagTask
+	tasks []*task
+	ctx   context.Context
+	// cancels ctx when a task fails in fail-fast mode
+	cancel context.CancelFunc
+	// number of unfinished tasks that each task depends on
+	waiting    map[*task]int
+	downstream map[*task][]*task
+	// ready tasks waiting for slots, in order of starting
+	ready []*task
+	// number of unfinished tasks
+	pending  int
+	results  chan attemptResult
+	retries  chan *task
+	wake     chan struct{}
+	firstErr error
+}
+
+type attemptResult struct {
+	t    *task
+	slot *poolSlot
+	err  error
+}
+
+func newDispatcher(ctx context.Context, cancel context.CancelFunc, d *dagTask, tasks []*task) *dispatcher {
+	s := &dispatcher{
+		d:          d,
+		tasks:      tasks,
+		ctx:        ctx,
+		cancel:     cancel,
+		waiting:    map[*task]int{},
+		downstream: downstreams(tasks),
+		pending:    len(tasks),
+		results:    make(chan attemptResult),
+		retries:    make(chan *task),
+		wake:       make(chan struct{}, 1),
+	}
+	for _, t := range tasks {
+		for _, next := range s.downstream[t] {
+			s.waiting[next]++
+		}
+	}
+	setPathLens(tasks, s.downstream)
+	return s
+}
+
+// downstreams returns tasks depending on each task, tasks not selected are
+// ignored
+func downstreams(tasks []*task) map[*task][]*task {
+	selected := make(map[*task]bool, len(tasks))
+	for _, t := range tasks {
+		selected[t] = true
+	}
+	downstream := map[*task][]*task{}
+	for _, t := range tasks {
+		for _, dep := range t.dependOn {
+			if selected[dep] {
+				downstream[dep] = append(downstream[dep], t)
+			}
+		}
+	}
+	return downstream
+}
+
+// setPathLens sets the number of tasks of the longest chain starting from each
+// task, tasks should be after tasks they depend on
+func setPathLens(tasks []*task, downstream map[*task][]*task) {
+	for i := len(tasks) - 1; i >= 0; i-- {
+		t := tasks[i]
+		t.pathLen = 1
+		for _, next := range downstream[t] {
+			if next.pathLen+1 > t.pathLen {
+				t.pathLen = next.pathLen + 1
+			}
+		}
+	}
+}
+
+// before tells whether t starts before other when both are ready: the one of
+// higher priority, then the one with the longer critical path, then the one
+// declared first
+func (t *task) before(other *task) bool {
+	if t.priority != other.priority {
+		return t.priority > other.priority
+	}
+	if t.pathLen != other.pathLen {
+		return t.pathLen > other.pathLen
+	}
+	return t.index < other.index
+}
+
+// context returns ctx of the run for t, tasks running after failures aren't
+// canceled in fail-fast mode
+func (t *task) context(ctx context.Context) context.Context {
+	if t.trigger.runsAfterFailure() {
+		return baseContext(ctx)
+	}
+	return ctx
+}
+
+// run returns after all tasks finish
+func (s *dispatcher) run() {
+	// slots may be released by tasks of other dags sharing pools, or by
+	// sleeping tasks
+	for _, pool := range s.d.pools {
+		defer pool.watch(s.wake)()
+	}
+	// tasks succeeded in the resumed run are finished already
+	for _, t := range s.tasks {
+		if t.restored {
+			s.pending--
+			for _, next := range s.downstream[t] {
+				s.waiting[next]--
+			}
+		}
+	}
+	var roots []*task
+	for _, t := range s.tasks {
+		if !t.restored && s.waiting[t] == 0 {
+			roots = append(roots, t)
+		}
+	}
+	for _, t := range roots {
+		s.prepare(t)
+	}
+	ctxDone, baseDone := s.ctx.Done(), baseContext(s.ctx).Done()
+	for {
+		s.dispatch()
+		if s.pending == 0 {
+			return
+		}
+		select {
+		case r := <-s.results:
+			// slots are released here so that tasks depending on r.t
+			// are ready before others start in them
+			r.slot.release()
+			s.attemptDone(r.t, r.err)
+		case t := <-s.retries:
+			s.queue(t)
+		case <-s.wake:
+		case <-ctxDone:
+			ctxDone = nil
+		case <-baseDone:
+			baseDone = nil
+		}
+	}
+}
+
+// prepare queues t whose depended tasks all finished, or finishes it if it
+// shouldn't run
+func (s *dispatcher) prepare(t *task) {
+	if err := t.prepare(s.ctx); err != nil {
+		s.finish(t, err)
+		return
+	}
+	emit(s.ctx, Event{Type: EventTaskQueued, Task: t.Name()})
+	s.queue(t)
+}
+
+func (s *dispatcher) queue(t *task) {
+	i := sort.Search(len(s.ready), func(i int) bool {
+		return t.before(s.ready[i])
+	})
+	s.ready = append(s.ready, nil)
+	copy(s.ready[i+1:], s.ready[i:])
+	s.ready[i] = t
+}
+
+// dispatch starts ready tasks in order while there are free slots. A task
+// lacking slots of a pool keeps tasks after it from taking slots of the pool
+// so that tasks taking many slots are not starved.
+func (s *dispatcher) dispatch() {
+	blocked := map[*RunnerPool]bool{}
+	for i := 0; i < len(s.ready); i++ {
+		t := s.ready[i]
+		ctx := t.context(s.ctx)
+		if ctx.Err() != nil {
+			s.ready = append(s.ready[:i], s.ready[i+1:]...)
+			s.settle(t, errors.WithStack(ctx.Err()))
+			// finished tasks may make others ready
+			i = -1
+			continue
+		}
+		slot := &poolSlot{uses: t.pools, pools: t.dagPools}
+		ok, err := slot.tryAcquire(blocked)
+		if err == nil && !ok {
+			continue
+		}
+		s.ready = append(s.ready[:i], s.ready[i+1:]...)
+		if err != nil {
+			t.state = StateFailed
+			s.finish(t, errors.WithStack(err))
+			i = -1
+			continue
+		}
+		s.start(ctx, t, slot)
+		i--
+	}
+}
+
+// start runs an attempt of t holding slot
+func (s *dispatcher) start(ctx context.Context, t *task, slot *poolSlot) {
+	t.attempts++
+	if t.attempts == 1 {
+		t.start = time.Now()
+	}
+	go func() {
+		err := t.runOnce(ctx, slot)
+		s.results <- attemptResult{t: t, slot: slot, err: err}
+	}()
+}
+
+// attemptDone retries t if needed, or finishes it
+func (s *dispatcher) attemptDone(t *task, err error) {
+	ctx := t.context(s.ctx)
+	// the whole dag is canceled or timed out
+	if ctx.Err() != nil || !t.retry.ShouldRetry(t.attempts, err) {
+		s.settle(t, err)
+		return
+	}
+	delay := t.retry.BackoffDelay(t.attempts)
+	emit(ctx, Event{Type: EventTaskRetried, Task: t.Name(), Attempt: t.attempts, Err: err, Delay: delay})
+	t.logger.Info("retry task", F("name", t.Name()), F("attempt", t.attempts),
+		F("delay", delay), F("error", err))
+	go func() {
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+		case <-timer.C:
+		}
+		s.retries <- t
+	}()
+}
+
+// settle sets the final state of t by the error of its last attempt
+func (s *dispatcher) settle(t *task, err error) {
+	if t.attempts > 0 {
+		t.end = time.Now()
+	}
+	switch {
+	case err == nil:
+		t.state = StateSucceeded
+	case t.attempts == 0:
+		// canceled before it got a runner
+		t.state = StateSkipped
+	case errors.Cause(err) == context.Canceled:
+		t.state = StateCancelled
+	default:
+		t.state = StateFailed
+	}
+	if err == nil {
+		for _, fn := range t.postRunHooks {
+			if err = fn(t); err != nil {
+				t.state = StateFailed
+				err = errors.Wrap(err, "postRunHooks fails")
+				break
+			}
+		}
+	}
+	s.finish(t, err)
+}
+
+// finish records the final state of t and makes tasks depending on it ready
+func (s *dispatcher) finish(t *task, err error) {
+	t.err = err
+	s.pending--
+	emit(s.ctx, Event{Type: finishEvent(t.state), Task: t.Name(), Attempt: t.attempts, State: t.state, Err: t.err})
+	s.d.saveTaskState(t)
+	if t.state == StateFailed && s.d.mode == FailFast && s.firstErr == nil {
+		s.firstErr = errors.Wrapf(t.err, "task %s", t.Name())
+		s.d.logger.Debug("error:", F("error", t.err), F("name", t.Name()))
+		s.cancel()
+	}
+	for _, next := range s.downstream[t] {
+		s.waiting[next]--
+		if s.waiting[next] == 0 {
+			s.prepare(next)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package task
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDispatchOrder(t *testing.T) {
+	echo := func(name string, extra ...interface{}) map[string]interface{} {
+		conf := map[string]interface{}{"name": name, "type": "echo", "echostr": name}
+		for i := 0; i < len(extra); i += 2 {
+			conf[extra[i].(string)] = extra[i+1]
+		}
+		return conf
+	}
+	d, err := CreateTaskDag(DagTaskConfig{
+		ConcurrentLimit: 1,
+		Tasks: []map[string]interface{}{
+			echo("a"),
+			echo("b", "priority", 5),
+			echo("c"),
+			echo("c2", "dependOn", []interface{}{"c"}),
+			echo("c3", "dependOn", []interface{}{"c2"}),
+			echo("d"),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.SetLogger(nil)
+	d.SetOutputSink(NewMemorySink())
+	var started []string
+	d.AddObserver(ObserverFunc(func(e Event) {
+		if e.Type == EventTaskStarted {
+			started = append(started, e.Task)
+		}
+	}))
+	// priority first, then the longest chain, then the declaration order
+	expected := "b,c,c2,a,c3,d"
+	for i := 0; i < 3; i++ {
+		started = nil
+		if err := d.Run(); err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(started, ",") != expected {
+			t.Fatalf("unexpected order %v", started)
+		}
+	}
+
+	p, err := d.Plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var waves []string
+	for _, wave := range p.Waves {
+		waves = append(waves, wave[0].Name)
+	}
+	if strings.Join(waves, ",") != "b,c,a,d,c2,c3" {
+		t.Fatalf("unexpected waves %v", waves)
+	}
+
+	if _, err := NewTask("echo", echo("a", "priority", "high")); err == nil ||
+		!strings.Contains(err.Error(), "priority") {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
@@ -0,0 +1,206 @@
+package task
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// EventType is the type of lifecycle events of dag runs
+type EventType string
+
+const (
+	EventDagStarted EventType = "dag_started"
+	// depended tasks finished and the task waits for a slot of the pool
+	EventTaskQueued EventType = "task_queued"
+	// an attempt of the task started
+	EventTaskStarted EventType = "task_started"
+	// an attempt failed and the task runs again after Delay
+	EventTaskRetried   EventType = "task_retried"
+	EventTaskSucceeded EventType = "task_succeeded"
+	// State is failed or cancelled
+	EventTaskFailed EventType = "task_failed"
+	// State is skipped or upstream_failed
+	EventTaskSkipped EventType = "task_skipped"
+	EventDagFinished EventType = "dag_finished"
+)
+
+// Event is sent to observers of a dag. Task is empty for dag events, State is
+// the final state of finished tasks, Result is only set for EventDagFinished.
+type Event struct {
+	Type    EventType
+	RunID   string
+	Time    time.Time
+	Task    string
+	Attempt int
+	State   TaskState
+	Err     error
+	Delay   time.Duration
+	Result  *RunResult
+}
+
+// Observer receives events of runs of a dag. Events are delivered in order
+// by a goroutine of each observer so that observers don't block tasks. Up to
+// eventQueueSize events wait for an observer, later ones are dropped, and the
+// run waits at most eventDrainTimeout for events to be delivered after all
+// tasks finish. Tasks restored by Resume have no events.
+type Observer interface {
+	OnEvent(Event)
+}
+
+// ObserverFunc is an Observer of a function
+type ObserverFunc func(Event)
+
+func (fn ObserverFunc) OnEvent(e Event) {
+	fn(e)
+}
+
+// AddObserver adds an observer of later runs of the dag
+func (d *dagTask) AddObserver(o Observer) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.observers = append(d.observers, o)
+}
+
+var (
+	// max number of events waiting for an observer
+	eventQueueSize = 1000
+	// max time a run waits for observers after all tasks finish
+	eventDrainTimeout = 10 * time.Second
+)
+
+// eventQueue delivers events to an observer in order
+type eventQueue struct {
+	observer Observer
+	lock     sync.Mutex
+	cond     *sync.Cond
+	events   []Event
+	// number of events dropped as the queue is full
+	dropped int
+	closed  bool
+	done    chan struct{}
+}
+
+func newEventQueue(o Observer) *eventQueue {
+	q := &eventQueue{observer: o, done: make(chan struct{})}
+	q.cond = sync.NewCond(&q.lock)
+	go q.loop()
+	return q
+}
+
+func (q *eventQueue) push(e Event) {
+	q.lock.Lock()
+	if len(q.events) >= eventQueueSize {
+		q.dropped++
+	} else {
+		q.events = append(q.events, e)
+	}
+	q.lock.Unlock()
+	q.cond.Signal()
+}
+
+func (q *eventQueue) loop() {
+	defer close(q.done)
+	for {
+		q.lock.Lock()
+		for len(q.events) == 0 && !q.closed {
+			q.cond.Wait()
+		}
+		events := q.events
+		q.events = nil
+		closed := q.closed
+		q.lock.Unlock()
+		for _, e := range events {
+			q.observer.OnEvent(e)
+		}
+		if closed && len(events) == 0 {
+			return
+		}
+	}
+}
+
+// close waits until all events are delivered or deadline is done, it returns
+// the number of events dropped or not delivered
+func (q *eventQueue) close(deadline <-chan struct{}) (lost int) {
+	q.lock.Lock()
+	q.closed = true
+	q.lock.Unlock()
+	q.cond.Signal()
+	select {
+	case <-q.done:
+	case <-deadline:
+	}
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.dropped + len(q.events)
+}
+
+// emitter sends events of a run to all observers
+type emitter struct {
+	runID  string
+	queues []*eventQueue
+}
+
+func newEmitter(runID string, observers []Observer) *emitter {
+	if len(observers) == 0 {
+		return nil
+	}
+	em := &emitter{runID: runID}
+	for _, o := range observers {
+		em.queues = append(em.queues, newEventQueue(o))
+	}
+	return em
+}
+
+func (em *emitter) emit(e Event) {
+	if em == nil {
+		return
+	}
+	e.RunID = em.runID
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	for _, q := range em.queues {
+		q.push(e)
+	}
+}
+
+// close waits for observers up to eventDrainTimeout, observers losing events
+// are logged
+func (em *emitter) close(logger Logger) {
+	if em == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), eventDrainTimeout)
+	defer cancel()
+	for _, q := range em.queues {
+		// a timed out queue keeps delivering until its observer returns
+		if lost := q.close(ctx.Done()); lost > 0 {
+			logger.Warn("events lost by observer", F("runID", em.runID), F("lost", lost))
+		}
+	}
+}
+
+type emitterKey struct{}
+
+func withEmitter(ctx context.Context, em *emitter) context.Context {
+	return context.WithValue(ctx, emitterKey{}, em)
+}
+
+// emit sends an event to observers of the running dag
+func emit(ctx context.Context, e Event) {
+	if em, ok := ctx.Value(emitterKey{}).(*emitter); ok {
+		em.emit(e)
+	}
+}
+
+// finishEvent returns the type of the event of the final state of a task
+func finishEvent(state TaskState) EventType {
+	switch state {
+	case StateSucceeded:
+		return EventTaskSucceeded
+	case StateSkipped, StateUpstreamFailed:
+		return EventTaskSkipped
+	}
+	return EventTaskFailed
+}
@@ -0,0 +1,94 @@
+package task
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestObserver(t *testing.T) {
+	d, err := CreateTaskDag(DagTaskConfig{
+		Tasks: []map[string]interface{}{
+			{"name": "a", "type": "sh", "shellcmd": "test -f retried || { touch retried; exit 1; }",
+				"shellcwd": t.TempDir(), "retries": 1},
+			{"name": "b", "type": "sh", "shellcmd": "exit 1", "dependOn": []interface{}{"a"}},
+			{"name": "c", "type": "echo", "echostr": "c", "dependOn": []interface{}{"b"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.SetLogger(nil)
+	d.SetOutputSink(NewMemorySink())
+	var lock sync.Mutex
+	var events []string
+	var finished Event
+	d.AddObserver(ObserverFunc(func(e Event) {
+		// slow observers don't block tasks
+		time.Sleep(10 * time.Millisecond)
+		lock.Lock()
+		defer lock.Unlock()
+		events = append(events, strings.TrimSpace(string(e.Type)+" "+e.Task))
+		if e.Type == EventDagFinished {
+			finished = e
+		}
+	}))
+	start := time.Now()
+	if err := d.Run(); err == nil {
+		t.Fatal("expect error")
+	}
+	expected := []string{
+		"dag_started",
+		"task_queued a", "task_started a", "task_retried a", "task_started a", "task_succeeded a",
+		"task_queued b", "task_started b", "task_failed b",
+		"task_skipped c",
+		"dag_finished",
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if strings.Join(events, ",") != strings.Join(expected, ",") {
+		t.Fatalf("unexpected events %v", events)
+	}
+	if finished.Err == nil || finished.Result == nil || finished.Result.Succeeded || finished.RunID == "" {
+		t.Fatalf("unexpected event %+v", finished)
+	}
+	if time.Since(start) < 100*time.Millisecond {
+		t.Fatal("run returns before events are delivered")
+	}
+}
+
+func TestHungObserver(t *testing.T) {
+	defer func(size int, timeout time.Duration) {
+		eventQueueSize, eventDrainTimeout = size, timeout
+	}(eventQueueSize, eventDrainTimeout)
+	eventQueueSize, eventDrainTimeout = 2, 50*time.Millisecond
+	d, err := CreateTaskDag(DagTaskConfig{
+		Tasks: []map[string]interface{}{
+			{"name": "a", "type": "echo", "echostr": "a"},
+			{"name": "b", "type": "echo", "echostr": "b", "dependOn": []interface{}{"a"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msgs []string
+	d.SetLogger(recordLogger{lock: &sync.Mutex{}, msgs: &msgs})
+	d.SetOutputSink(NewMemorySink())
+	hang := make(chan struct{})
+	defer close(hang)
+	d.AddObserver(ObserverFunc(func(Event) {
+		<-hang
+	}))
+	start := time.Now()
+	if err := d.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatal("run is blocked by the observer")
+	}
+	// events beyond the queue size are dropped, queued ones are not delivered
+	if msg := msgs[len(msgs)-1]; !strings.HasPrefix(msg, "events lost by observer") {
+		t.Fatalf("unexpected log %q", msg)
+	}
+}
@@ -0,0 +1,307 @@
+package task
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// A tiny expression language for the when field of tasks, like
+//
+//	params.env == "prod" && tasks.count.outputs.rows > 0
+//
+// Operands are variables (same as those in templates without braces), quoted
+// strings, numbers, true and false. Operators are ! && || == != < <= > >= and
+// parentheses. Values are compared as numbers if both look like numbers.
+// A value is true unless it's empty, "false" or "0".
+type expr interface {
+	eval(lookup lookupFunc) (string, error)
+}
+
+type literalExpr string
+
+type varExpr string
+
+type notExpr struct {
+	x expr
+}
+
+type binaryExpr struct {
+	op   string
+	x, y expr
+}
+
+func (e literalExpr) eval(lookup lookupFunc) (string, error) {
+	return string(e), nil
+}
+
+func (e varExpr) eval(lookup lookupFunc) (string, error) {
+	value, ok, err := lookup(string(e), "")
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", fmt.Errorf("unknown variable %s", string(e))
+	}
+	return value, nil
+}
+
+func (e notExpr) eval(lookup lookupFunc) (string, error) {
+	v, err := e.x.eval(lookup)
+	if err != nil {
+		return "", err
+	}
+	return boolString(!truthy(v)), nil
+}
+
+func (e binaryExpr) eval(lookup lookupFunc) (string, error) {
+	x, err := e.x.eval(lookup)
+	if err != nil {
+		return "", err
+	}
+	// short circuit
+	switch {
+	case e.op == "&&" && !truthy(x):
+		return "false", nil
+	case e.op == "||" && truthy(x):
+		return "true", nil
+	}
+	y, err := e.y.eval(lookup)
+	if err != nil {
+		return "", err
+	}
+	switch e.op {
+	case "&&", "||":
+		return boolString(truthy(y)), nil
+	}
+	var cmp int
+	xf, errx := strconv.ParseFloat(x, 64)
+	yf, erry := strconv.ParseFloat(y, 64)
+	if errx == nil && erry == nil {
+		switch {
+		case xf < yf:
+			cmp = -1
+		case xf > yf:
+			cmp = 1
+		}
+	} else {
+		cmp = strings.Compare(x, y)
+	}
+	switch e.op {
+	case "==":
+		return boolString(cmp == 0), nil
+	case "!=":
+		return boolString(cmp != 0), nil
+	case "<":
+		return boolString(cmp < 0), nil
+	case "<=":
+		return boolString(cmp <= 0), nil
+	case ">":
+		return boolString(cmp > 0), nil
+	case ">=":
+		return boolString(cmp >= 0), nil
+	}
+	return "", fmt.Errorf("unknown operator %s", e.op)
+}
+
+func truthy(v string) bool {
+	return v != "" && v != "false" && v != "0"
+}
+
+func boolString(b bool) string {
+	if b {
+		return "true"
+	}
+	return "false"
+}
+
+// evalBool evaluates e and tells whether the result is true
+func evalBool(e expr, lookup lookupFunc) (bool, error) {
+	v, err := e.eval(lookup)
+	if err != nil {
+		return false, err
+	}
+	return truthy(v), nil
+}
+
+// exprVars returns names of variables used in e
+func exprVars(e expr) []string {
+	switch e := e.(type) {
+	case varExpr:
+		return []string{string(e)}
+	case notExpr:
+		return exprVars(e.x)
+	case binaryExpr:
+		return append(exprVars(e.x), exprVars(e.y)...)
+	}
+	return nil
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokOp
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+}
+
+func tokenize(s string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '"' || c == '\'':
+			j := strings.IndexByte(s[i+1:], c)
+			if j < 0 {
+				return nil, fmt.Errorf("unterminated string at %d", i)
+			}
+			tokens = append(tokens, token{tokString, s[i+1 : i+1+j]})
+			i += j + 2
+		case c >= '0' && c <= '9' || c == '-' && i+1 < len(s) && s[i+1] >= '0' && s[i+1] <= '9':
+			j := i + 1
+			for j < len(s) && (s[j] >= '0' && s[j] <= '9' || s[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, token{tokNumber, s[i:j]})
+			i = j
+		case c == '_' || unicode.IsLetter(rune(c)):
+			j := i + 1
+			for j < len(s) && (s[j] == '_' || s[j] == '.' || s[j] == '-' ||
+				unicode.IsLetter(rune(s[j])) || unicode.IsDigit(rune(s[j]))) {
+				j++
+			}
+			tokens = append(tokens, token{tokIdent, s[i:j]})
+			i = j
+		default:
+			op := ""
+			for _, o := range []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!", "(", ")"} {
+				if strings.HasPrefix(s[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected %q at %d", c, i)
+			}
+			tokens = append(tokens, token{tokOp, op})
+			i += len(op)
+		}
+	}
+	return append(tokens, token{kind: tokEOF}), nil
+}
+
+type exprParser struct {
+	tokens []token
+	pos    int
+}
+
+func parseExpr(s string) (expr, error) {
+	tokens, err := tokenize(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{tokens: tokens}
+	e, err := p.parseBinary(0)
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.next(); tok.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %s", tok.value)
+	}
+	return e, nil
+}
+
+// operators from low to high precedence
+var precedences = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!=", "<", "<=", ">", ">="},
+}
+
+func (p *exprParser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *exprParser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *exprParser) parseBinary(level int) (expr, error) {
+	if level == len(precedences) {
+		return p.parseUnary()
+	}
+	x, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok := p.peek()
+		if tok.kind != tokOp || !contains(precedences[level], tok.value) {
+			return x, nil
+		}
+		p.next()
+		y, err := p.parseBinary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		x = binaryExpr{op: tok.value, x: x, y: y}
+	}
+}
+
+func (p *exprParser) parseUnary() (expr, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokString, tokNumber:
+		return literalExpr(tok.value), nil
+	case tokIdent:
+		if tok.value == "true" || tok.value == "false" {
+			return literalExpr(tok.value), nil
+		}
+		return varExpr(tok.value), nil
+	case tokOp:
+		switch tok.value {
+		case "!":
+			x, err := p.parseUnary()
+			if err != nil {
+				return nil, err
+			}
+			return notExpr{x}, nil
+		case "(":
+			x, err := p.parseBinary(0)
+			if err != nil {
+				return nil, err
+			}
+			if tok := p.next(); tok.value != ")" {
+				return nil, fmt.Errorf("expect ), got %q", tok.value)
+			}
+			return x, nil
+		}
+	}
+	if tok.kind == tokEOF {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	return nil, fmt.Errorf("unexpected %s", tok.value)
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
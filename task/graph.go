@@ -0,0 +1,55 @@
+package task
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/zxdvd/go-libs/dag"
+)
+
+// GraphFormat is the format of the exported task graph
+type GraphFormat string
+
+const (
+	GraphDOT     GraphFormat = "dot"
+	GraphMermaid GraphFormat = "mermaid"
+	GraphJSON    GraphFormat = "json"
+)
+
+// fill colors of task states, pending tasks are not colored
+var stateColors = map[TaskState]string{
+	StateSucceeded:      "#a5d6a7",
+	StateFailed:         "#ef9a9a",
+	StateUpstreamFailed: "#ffcc80",
+	StateCancelled:      "#fff59d",
+	StateSkipped:        "#e0e0e0",
+}
+
+// WriteGraph writes the dag with edges from depended tasks to tasks depending
+// on them. Tasks are colored by their states if states is not nil, States() of
+// the RunResult gives states of the last run.
+func (d *dagTask) WriteGraph(w io.Writer, format GraphFormat, states map[string]TaskState) error {
+	opts := dag.ExportOptions{
+		Reverse: true,
+		Attrs: func(node dag.Node) dag.NodeAttrs {
+			t := node.(*task)
+			attrs := dag.NodeAttrs{
+				ID:    t.Name(),
+				Label: fmt.Sprintf("%s (%s)", t.Name(), t.typ),
+			}
+			if state, ok := states[t.Name()]; ok {
+				attrs.Color = stateColors[state]
+			}
+			return attrs
+		},
+	}
+	switch format {
+	case GraphDOT:
+		return d.WriteDOT(w, opts)
+	case GraphMermaid:
+		return d.WriteMermaid(w, opts)
+	case GraphJSON:
+		return d.Dag.WriteJSON(w, opts)
+	}
+	return fmt.Errorf("unknown graph format %q", format)
+}
@@ -0,0 +1,39 @@
+package task
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteGraph(t *testing.T) {
+	d, err := CreateTaskDag(DagTaskConfig{
+		Mode: ContinueOnError,
+		Tasks: []map[string]interface{}{
+			{"name": "fail", "type": "sh", "shellcmd": "exit 1"},
+			{"name": "ok", "type": "echo", "echostr": "ok"},
+			{"name": "after_fail", "type": "echo", "echostr": "x", "dependOn": []interface{}{"fail", "ok"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Run()
+	var buf bytes.Buffer
+	if err := d.WriteGraph(&buf, GraphMermaid, d.Result().States()); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{`n0["fail (sh)"]`, "n0 --> n2", "style n0 fill:" + stateColors[StateFailed]} {
+		if !strings.Contains(buf.String(), s) {
+			t.Fatalf("expect %s in graph %s", s, buf.String())
+		}
+	}
+	buf.Reset()
+	d.WriteGraph(&buf, GraphDOT, nil)
+	if !strings.Contains(buf.String(), `"ok" -> "after_fail";`) || strings.Contains(buf.String(), "fillcolor") {
+		t.Fatalf("unexpected graph %s", buf.String())
+	}
+	if err := d.WriteGraph(&buf, "svg", nil); err == nil {
+		t.Fatal("expect error of unknown format")
+	}
+}
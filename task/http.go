@@ -0,0 +1,350 @@
+package task
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// max size of response bodies read by HttpTask
+const maxResponseSize = 16 << 20
+
+// max bytes of the response body kept for output "body", outputs are saved in
+// checkpoints and reports
+var maxBodyOutput = 1 << 20
+
+// HttpTask sends a request and fails if the response status is not expected,
+// any 2xx status is expected by default. Config fields are
+//
+//	method              GET by default
+//	url
+//	headers             mapping of header names to values
+//	body                string, or a mapping/list sent as json
+//	expectStatus        a status code or a list of them
+//	capture             mapping of output names to parts of the response
+//	requestTimeout      timeout of the request, the task timeout still applies
+//	insecureSkipVerify  don't verify certificates of the server
+//	caFile              pem file of CA certificates to verify the server
+//	certFile, keyFile   client certificate
+//
+// The status and the first maxBodyOutput bytes of the body are published as
+// outputs "status" and "body". Parts of the response in capture are like
+// "status", "body", "header.NAME" and "body.PATH" where PATH like
+// data.items.0.id selects a value of the json body, they see the whole body.
+type HttpTask struct {
+	baseTask
+	method       string
+	url          string
+	headers      map[string]string
+	body         []byte
+	expectStatus []int
+	capture      map[string]string
+	client       *http.Client
+}
+
+type HttpConfig struct {
+	Method       string            `task:"method" default:"GET" desc:"request method"`
+	URL          string            `task:"url,required" desc:"request url"`
+	Headers      map[string]string `task:"headers" desc:"request headers"`
+	Body         interface{}       `task:"body" desc:"string, or a mapping/list sent as json"`
+	ExpectStatus []int             `task:"expectStatus" desc:"expected status codes, any 2xx by default"`
+	Capture      map[string]string `task:"capture" desc:"output names to parts of the response"`
+	HttpClientConfig
+}
+
+// HttpClientConfig is shared by tasks sending requests
+type HttpClientConfig struct {
+	RequestTimeout     time.Duration `task:"requestTimeout" desc:"timeout of the request"`
+	InsecureSkipVerify bool          `task:"insecureSkipVerify" desc:"don't verify certificates of the server"`
+	CaFile             string        `task:"caFile" desc:"pem file of CA certificates to verify the server"`
+	CertFile           string        `task:"certFile" desc:"client certificate"`
+	KeyFile            string        `task:"keyFile" desc:"key of the client certificate"`
+}
+
+func newHttpTask(name string, config interface{}) (Task, error) {
+	c, ok := config.(*HttpConfig)
+	if !ok {
+		return nil, errors.New("failed to newHttpTask, wrong config")
+	}
+	t := &HttpTask{
+		method:       strings.ToUpper(c.Method),
+		url:          c.URL,
+		headers:      map[string]string{},
+		expectStatus: c.ExpectStatus,
+		capture:      map[string]string{},
+	}
+	t.name = name
+	errs := &ConfigError{Task: name}
+	if t.method == "" {
+		errs.add("method", errors.New("empty"))
+	}
+	for k, v := range c.Headers {
+		t.headers[k] = v
+	}
+	switch body := c.Body.(type) {
+	case nil:
+	case string:
+		t.body = []byte(body)
+	case map[string]interface{}, []interface{}:
+		var err error
+		if t.body, err = json.Marshal(body); err != nil {
+			errs.add("body", err)
+		}
+		if _, ok := t.header("Content-Type"); !ok {
+			t.headers["Content-Type"] = "application/json"
+		}
+	default:
+		errs.add("body", fmt.Errorf("expect a string, a mapping or a list, got %v", body))
+	}
+	for output, part := range c.Capture {
+		if part != "status" && part != "body" && !strings.HasPrefix(part, "body.") &&
+			!strings.HasPrefix(part, "header.") {
+			errs.add("capture", fmt.Errorf("bad part %s of %s", part, output))
+			continue
+		}
+		t.capture[output] = part
+	}
+	client, err := newHttpClient(&c.HttpClientConfig)
+	errs.add("", err)
+	if err := errs.err(); err != nil {
+		return nil, err
+	}
+	t.client = client
+	return t, nil
+}
+
+// transports are shared by clients of the same tls config, so that idle
+// connections are reused instead of piling up in clients of each run. A
+// transport is replaced once its certificate files are modified, like rotated.
+var transports = struct {
+	lock sync.Mutex
+	m    map[transportKey]*cachedTransport
+}{m: map[transportKey]*cachedTransport{}}
+
+type transportKey struct {
+	insecureSkipVerify        bool
+	caFile, certFile, keyFile string
+}
+
+type cachedTransport struct {
+	transport *http.Transport
+	// stamps of files when the transport is created
+	stamps [3]fileStamp
+}
+
+type fileStamp struct {
+	modTime int64
+	size    int64
+}
+
+// stamps returns stamps of caFile, certFile and keyFile
+func (key transportKey) stamps() ([3]fileStamp, error) {
+	var stamps [3]fileStamp
+	for i, path := range []string{key.caFile, key.certFile, key.keyFile} {
+		if path == "" {
+			continue
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			return stamps, err
+		}
+		stamps[i] = fileStamp{fi.ModTime().UnixNano(), fi.Size()}
+	}
+	return stamps, nil
+}
+
+func newHttpClient(c *HttpClientConfig) (*http.Client, error) {
+	transport, err := sharedTransport(transportKey{c.InsecureSkipVerify, c.CaFile, c.CertFile, c.KeyFile})
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{Timeout: c.RequestTimeout, Transport: transport}, nil
+}
+
+func sharedTransport(key transportKey) (*http.Transport, error) {
+	transports.lock.Lock()
+	defer transports.lock.Unlock()
+	stamps, err := key.stamps()
+	if err != nil {
+		return nil, err
+	}
+	cached, ok := transports.m[key]
+	if ok && cached.stamps == stamps {
+		return cached.transport, nil
+	}
+	tlsConfig := &tls.Config{InsecureSkipVerify: key.insecureSkipVerify}
+	if key.caFile != "" {
+		pem, err := ioutil.ReadFile(key.caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", key.caFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if key.certFile != "" || key.keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(key.certFile, key.keyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	if ok {
+		// clients using it still work, only its idle connections are closed
+		cached.transport.CloseIdleConnections()
+	}
+	transports.m[key] = &cachedTransport{transport, stamps}
+	return transport, nil
+}
+
+// toStringMap copies a mapping of scalar values to m, nil is an empty mapping
+func toStringMap(v interface{}, m map[string]string) error {
+	if v == nil {
+		return nil
+	}
+	raw, ok := v.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("expect a mapping, got %v", v)
+	}
+	for k, v := range raw {
+		switch v.(type) {
+		case []interface{}, map[string]interface{}:
+			return fmt.Errorf("%s should be a scalar value", k)
+		}
+		m[k] = fmt.Sprint(v)
+	}
+	return nil
+}
+
+func (t *HttpTask) header(name string) (string, bool) {
+	for k, v := range t.headers {
+		if strings.EqualFold(k, name) {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+func (t *HttpTask) Run(ctx context.Context) error {
+	req, err := http.NewRequest(t.method, t.url, bytes.NewReader(t.body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	for k, v := range t.headers {
+		req.Header.Set(k, v)
+	}
+	out := Output(ctx)
+	fmt.Fprintf(out, "%s %s\n", t.method, t.url)
+	start := time.Now()
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "%s in %s\n", resp.Status, time.Since(start).Round(time.Millisecond))
+	out.Write(body)
+	if len(body) > 0 && body[len(body)-1] != '\n' {
+		fmt.Fprintln(out)
+	}
+	if !t.expected(resp.StatusCode) {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	SetOutput(ctx, "status", strconv.Itoa(resp.StatusCode))
+	if len(body) > maxBodyOutput {
+		SetOutput(ctx, "body", string(body[:maxBodyOutput]))
+	} else {
+		SetOutput(ctx, "body", string(body))
+	}
+	for name, part := range t.capture {
+		value, err := capturePart(resp, body, part)
+		if err != nil {
+			return errors.Wrapf(err, "capture %s", name)
+		}
+		SetOutput(ctx, name, value)
+	}
+	return nil
+}
+
+func (t *HttpTask) expected(status int) bool {
+	if len(t.expectStatus) == 0 {
+		return status >= 200 && status < 300
+	}
+	for _, code := range t.expectStatus {
+		if code == status {
+			return true
+		}
+	}
+	return false
+}
+
+func capturePart(resp *http.Response, body []byte, part string) (string, error) {
+	switch {
+	case part == "status":
+		return strconv.Itoa(resp.StatusCode), nil
+	case part == "body":
+		return string(body), nil
+	case strings.HasPrefix(part, "header."):
+		return resp.Header.Get(part[len("header."):]), nil
+	}
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		return "", errors.Wrap(err, "body is not json")
+	}
+	return jsonPath(v, part[len("body."):])
+}
+
+// jsonPath selects a value of decoded json by a path like data.items.0.id,
+// objects and arrays are returned as json
+func jsonPath(v interface{}, path string) (string, error) {
+	for _, key := range strings.Split(path, ".") {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if v, ok = node[key]; !ok {
+				return "", fmt.Errorf("%s not found", path)
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return "", fmt.Errorf("%s not found", path)
+			}
+			v = node[i]
+		default:
+			return "", fmt.Errorf("%s not found", path)
+		}
+	}
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case nil:
+		return "", nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case map[string]interface{}, []interface{}:
+		data, err := json.Marshal(v)
+		return string(data), err
+	}
+	return fmt.Sprint(v), nil
+}
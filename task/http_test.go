@@ -0,0 +1,174 @@
+package task
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHttpTask(t *testing.T) {
+	var got map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Header.Get("Content-Type") != "application/json" || r.Header.Get("X-Token") != "secret" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		w.Header().Set("Location", "/jobs/7")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"job": {"id": 7, "tags": ["a", "b"]}}`))
+	}))
+	defer server.Close()
+	defer func(size int) { maxBodyOutput = size }(maxBodyOutput)
+	maxBodyOutput = 8
+
+	d, err := CreateTaskDag(DagTaskConfig{
+		Params: map[string]string{"token": "secret"},
+		Tasks: []map[string]interface{}{
+			{"name": "size", "type": "sh", "shellcmd": "echo 42"},
+			{
+				"name":         "post",
+				"type":         "http",
+				"method":       "post",
+				"url":          server.URL + "/jobs",
+				"headers":      map[string]interface{}{"X-Token": "{params.token}"},
+				"body":         map[string]interface{}{"size": "{tasks.size.outputs.stdout}"},
+				"expectStatus": 201,
+				"capture": map[string]interface{}{
+					"id":       "body.job.id",
+					"tags":     "body.job.tags",
+					"location": "header.Location",
+				},
+				"dependOn": []interface{}{"size"},
+			},
+			{"name": "missing", "type": "http", "url": server.URL + "/missing"},
+			{"name": "missing_ok", "type": "http", "url": server.URL + "/missing",
+				"expectStatus": []interface{}{200, 404}},
+		},
+		Mode: ContinueOnError,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Run()
+	expected := map[string]TaskState{
+		"size":       StateSucceeded,
+		"post":       StateSucceeded,
+		"missing":    StateFailed,
+		"missing_ok": StateSucceeded,
+	}
+	if states := taskStates(d); !equalStates(states, expected) {
+		t.Fatalf("expect states %v, got %v", expected, states)
+	}
+	if got["size"] != "42" {
+		t.Fatalf("unexpected request body %v", got)
+	}
+	outputs := map[string]string{}
+	for _, tr := range d.Result().Tasks {
+		if tr.Name == "post" {
+			outputs = tr.Outputs
+		}
+	}
+	// capture sees the whole body while output body is cut
+	if outputs["status"] != "201" || outputs["id"] != "7" || outputs["tags"] != `["a","b"]` ||
+		outputs["location"] != "/jobs/7" || outputs["body"] != `{"job": ` {
+		t.Fatalf("unexpected outputs %v", outputs)
+	}
+}
+
+func TestNewHttpTask(t *testing.T) {
+	bad := []map[string]interface{}{
+		{"name": "a"},
+		{"name": "a", "url": "http://localhost", "expectStatus": "ok"},
+		{"name": "a", "url": "http://localhost", "capture": map[string]interface{}{"x": "cookie"}},
+		{"name": "a", "url": "http://localhost", "caFile": "/nonexistent"},
+	}
+	for _, conf := range bad {
+		if _, err := newTask("http", conf); err == nil {
+			t.Fatalf("expect error of %v", conf)
+		}
+	}
+
+	// tasks of the same tls config share the transport
+	var clients []*http.Client
+	for _, conf := range []map[string]interface{}{
+		{"name": "a", "url": "http://localhost"},
+		{"name": "b", "url": "http://localhost", "requestTimeout": "1s"},
+		{"name": "c", "url": "http://localhost", "insecureSkipVerify": true},
+	} {
+		task, err := newTask("http", conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		clients = append(clients, task.(*HttpTask).client)
+	}
+	if clients[0].Transport != clients[1].Transport || clients[0].Transport == clients[2].Transport {
+		t.Fatal("transports should be shared by tls configs")
+	}
+}
+
+func TestHttpTaskTLS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	ctx := withOutputs(context.Background(), &task{outputs: map[string]string{}})
+	for insecure, ok := range map[bool]bool{false: false, true: true} {
+		impl, err := newTask("http", map[string]interface{}{
+			"name":               "tls",
+			"url":                server.URL,
+			"insecureSkipVerify": insecure,
+			"requestTimeout":     "5s",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := impl.Run(ctx); (err == nil) != ok {
+			t.Fatalf("insecureSkipVerify %v: unexpected error %v", insecure, err)
+		}
+	}
+}
+
+func TestHttpTaskCaFile(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := ioutil.WriteFile(caFile, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	ctx := withOutputs(context.Background(), &task{outputs: map[string]string{}})
+	newClient := func() *http.Client {
+		impl, err := newTask("http", map[string]interface{}{
+			"name": "tls", "url": server.URL, "caFile": caFile, "requestTimeout": "5s",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := impl.Run(ctx); err != nil {
+			t.Fatal(err)
+		}
+		return impl.(*HttpTask).client
+	}
+	first, second := newClient(), newClient()
+	if first.Transport != second.Transport {
+		t.Fatal("transports should be shared by tls configs")
+	}
+	// like a rotated certificate
+	mtime := time.Now().Add(time.Hour)
+	if err := os.Chtimes(caFile, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	if third := newClient(); third.Transport == first.Transport {
+		t.Fatal("transport should be reloaded once caFile is modified")
+	}
+}
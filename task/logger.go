@@ -0,0 +1,134 @@
+package task
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Logger logs events of dag runs, like tasks starting and retrying. Adapters
+// of zap, slog (go1.21+) and a no-op one are provided.
+type Logger interface {
+	Debug(msg string, fields ...Field)
+	Info(msg string, fields ...Field)
+	Warn(msg string, fields ...Field)
+	Error(msg string, fields ...Field)
+	// With returns a logger adding fields to all messages
+	With(fields ...Field) Logger
+}
+
+// Field is a key value pair of a log message
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// F creates a Field
+func F(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+var defaultLogger Logger
+
+func init() {
+	l, _ := zap.NewDevelopment()
+	defaultLogger = NewZapLogger(l)
+}
+
+// SetDefaultLogger sets the logger of dags created after it and of new
+// schedulers, it's a zap development logger writing to stderr by default
+func SetDefaultLogger(l Logger) {
+	if l == nil {
+		l = NopLogger()
+	}
+	defaultLogger = l
+}
+
+// SetLogger sets the logger of the dag and its tasks, nil disables logging
+func (d *dagTask) SetLogger(l Logger) {
+	if l == nil {
+		l = NopLogger()
+	}
+	d.logger = l
+	for _, node := range d.Nodes() {
+		node.(*task).logger = l
+	}
+}
+
+type loggerKey struct{}
+
+// TaskLogger returns the logger of the running task, messages are logged with
+// the task name
+func TaskLogger(ctx context.Context) Logger {
+	if l, ok := ctx.Value(loggerKey{}).(Logger); ok {
+		return l
+	}
+	return defaultLogger
+}
+
+func withLogger(ctx context.Context, l Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+type zapLogger struct {
+	l *zap.Logger
+}
+
+// NewZapLogger adapts a zap logger
+func NewZapLogger(l *zap.Logger) Logger {
+	// report callers of the adapter
+	return zapLogger{l: l.WithOptions(zap.AddCallerSkip(1))}
+}
+
+func zapFields(fields []Field) []zap.Field {
+	zfs := make([]zap.Field, len(fields))
+	for i, f := range fields {
+		switch v := f.Value.(type) {
+		case error:
+			zfs[i] = zap.NamedError(f.Key, v)
+		case time.Duration:
+			zfs[i] = zap.Duration(f.Key, v)
+		default:
+			zfs[i] = zap.Any(f.Key, v)
+		}
+	}
+	return zfs
+}
+
+func (z zapLogger) Debug(msg string, fields ...Field) {
+	z.l.Debug(msg, zapFields(fields)...)
+}
+
+func (z zapLogger) Info(msg string, fields ...Field) {
+	z.l.Info(msg, zapFields(fields)...)
+}
+
+func (z zapLogger) Warn(msg string, fields ...Field) {
+	z.l.Warn(msg, zapFields(fields)...)
+}
+
+func (z zapLogger) Error(msg string, fields ...Field) {
+	z.l.Error(msg, zapFields(fields)...)
+}
+
+func (z zapLogger) With(fields ...Field) Logger {
+	return zapLogger{l: z.l.With(zapFields(fields)...)}
+}
+
+func (z zapLogger) Sync() error {
+	return z.l.Sync()
+}
+
+type nopLogger struct{}
+
+// NopLogger returns a logger discarding all messages
+func NopLogger() Logger {
+	return nopLogger{}
+}
+
+func (nopLogger) Debug(string, ...Field) {}
+func (nopLogger) Info(string, ...Field)  {}
+func (nopLogger) Warn(string, ...Field)  {}
+func (nopLogger) Error(string, ...Field) {}
+func (n nopLogger) With(...Field) Logger { return n }
@@ -0,0 +1,54 @@
+//go:build go1.21
+// +build go1.21
+
+package task
+
+import (
+	"context"
+	"log/slog"
+)
+
+type slogLogger struct {
+	l *slog.Logger
+}
+
+// NewSlogLogger adapts a slog logger
+func NewSlogLogger(l *slog.Logger) Logger {
+	return slogLogger{l: l}
+}
+
+func slogAttrs(fields []Field) []slog.Attr {
+	attrs := make([]slog.Attr, len(fields))
+	for i, f := range fields {
+		attrs[i] = slog.Any(f.Key, f.Value)
+	}
+	return attrs
+}
+
+func (s slogLogger) log(level slog.Level, msg string, fields []Field) {
+	s.l.LogAttrs(context.Background(), level, msg, slogAttrs(fields)...)
+}
+
+func (s slogLogger) Debug(msg string, fields ...Field) {
+	s.log(slog.LevelDebug, msg, fields)
+}
+
+func (s slogLogger) Info(msg string, fields ...Field) {
+	s.log(slog.LevelInfo, msg, fields)
+}
+
+func (s slogLogger) Warn(msg string, fields ...Field) {
+	s.log(slog.LevelWarn, msg, fields)
+}
+
+func (s slogLogger) Error(msg string, fields ...Field) {
+	s.log(slog.LevelError, msg, fields)
+}
+
+func (s slogLogger) With(fields ...Field) Logger {
+	args := make([]interface{}, len(fields))
+	for i, attr := range slogAttrs(fields) {
+		args[i] = attr
+	}
+	return slogLogger{l: s.l.With(args...)}
+}
@@ -0,0 +1,216 @@
+package task
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// MapTask runs an instance of a task for each item of mapOver when it runs.
+// mapOver is a list, or a string like {tasks.list.outputs.stdout} which is a
+// json array or one item per line. {item} and {index} in the config are
+// replaced by the item and its index from 0 for each instance, and {name} by
+// the name of the instance, like
+//
+//	name: export
+//	type: sh
+//	mapOver: "{tasks.tenants.outputs.stdout}"
+//	shellcmd: ./export.sh {item} > part-{index}.csv
+//
+// Instances are named like export[0], they share pools of the dag and all of
+// them run even if some fail. Retries, timeout and pools apply to each
+// instance.
+// Tasks depending on the map task run after all instances, outputs of
+// instances are published as INDEX.KEY like {tasks.export.outputs.0.stdout},
+// and KEY is a json array of values of all instances.
+type MapTask struct {
+	baseTask
+	typ string
+	// config of instances, without mapOver
+	config map[string]interface{}
+	items  []string
+	// unknown until outputs of upstream tasks are resolved
+	resolved bool
+	// instances of the last run
+	dag *dagTask
+}
+
+// createTask creates a MapTask if the config has mapOver, or the task of typ
+func createTask(typ string, conf map[string]interface{}) (Task, error) {
+	if _, ok := conf["mapOver"]; ok {
+		return newMapTask(typ, conf)
+	}
+	return newTask(typ, conf)
+}
+
+func copyConfig(conf map[string]interface{}) map[string]interface{} {
+	c := make(map[string]interface{}, len(conf))
+	for k, v := range conf {
+		c[k] = v
+	}
+	return c
+}
+
+func newMapTask(typ string, conf map[string]interface{}) (Task, error) {
+	t := &MapTask{typ: typ, config: copyConfig(conf)}
+	t.name, _ = conf["name"].(string)
+	delete(t.config, "mapOver")
+	// handled by the map task itself
+	for _, k := range []string{"dependOn", "when", "triggerRule"} {
+		delete(t.config, k)
+	}
+	switch v := conf["mapOver"].(type) {
+	case []interface{}:
+		for _, item := range v {
+			s, err := itemString(item)
+			if err != nil {
+				return nil, err
+			}
+			t.items = append(t.items, s)
+		}
+		t.resolved = true
+	case string:
+		// variables like outputs of upstream tasks are not known yet
+		if !hasTemplateVars(v) {
+			items, err := parseItems(v)
+			if err != nil {
+				return nil, err
+			}
+			t.items = items
+			t.resolved = true
+		}
+	default:
+		return nil, fmt.Errorf("mapOver should be a list or a string, got %v", v)
+	}
+	if !t.resolved {
+		if _, err := newTask(typ, t.config); err != nil {
+			return nil, err
+		}
+		return t, nil
+	}
+	// create instances to check their configs early
+	if _, err := t.instanceConfigs(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func hasTemplateVars(s string) bool {
+	for _, m := range patTemplateVar.FindAllStringSubmatch(s, -1) {
+		if isTemplateVar(m[1]) {
+			return true
+		}
+	}
+	return false
+}
+
+func itemString(item interface{}) (string, error) {
+	switch v := item.(type) {
+	case string:
+		return v, nil
+	case []interface{}, map[string]interface{}:
+		data, err := json.Marshal(item)
+		return string(data), err
+	}
+	return fmt.Sprint(item), nil
+}
+
+// parseItems parses a json array or lines of text
+func parseItems(s string) ([]string, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "[") {
+		var list []interface{}
+		if err := json.Unmarshal([]byte(s), &list); err != nil {
+			return nil, errors.Wrap(err, "bad mapOver")
+		}
+		items := make([]string, len(list))
+		for i, item := range list {
+			var err error
+			if items[i], err = itemString(item); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	var items []string
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			items = append(items, line)
+		}
+	}
+	return items, nil
+}
+
+func (t *MapTask) instanceName(index int) string {
+	return fmt.Sprintf("%s[%d]", t.name, index)
+}
+
+func (t *MapTask) instanceConfigs() ([]map[string]interface{}, error) {
+	confs := make([]map[string]interface{}, len(t.items))
+	for i, item := range t.items {
+		index := strconv.Itoa(i)
+		// instances expand their configs again when they run
+		conf, err := expander{keepEscapes: true, lookup: func(name, format string) (string, bool, error) {
+			switch name {
+			case "item":
+				return item, true, nil
+			case "index":
+				return index, true, nil
+			}
+			return "", false, nil
+		}}.config(t.config)
+		if err != nil {
+			return nil, err
+		}
+		conf["name"] = t.instanceName(i)
+		if _, err := newTask(t.typ, conf); err != nil {
+			return nil, errors.Wrapf(err, "instance %d", i)
+		}
+		confs[i] = conf
+	}
+	return confs, nil
+}
+
+func (t *MapTask) Run(ctx context.Context) error {
+	if !t.resolved {
+		return errors.New("mapOver is not resolved")
+	}
+	confs, err := t.instanceConfigs()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(Output(ctx), "map over %d items\n", len(confs))
+	if len(confs) == 0 {
+		return nil
+	}
+	d, err := CreateTaskDag(DagTaskConfig{Tasks: confs, Mode: ContinueOnError, inheritPools: true})
+	if err != nil {
+		return err
+	}
+	t.dag = d
+	err = runSubDag(ctx, d)
+	// instances are added in the order of items
+	values := map[string][]string{}
+	for i, node := range d.Nodes() {
+		for k, v := range node.(*task).copyOutputs() {
+			SetOutput(ctx, strconv.Itoa(i)+"."+k, v)
+			if values[k] == nil {
+				values[k] = make([]string, len(confs))
+			}
+			values[k][i] = v
+		}
+	}
+	for k, list := range values {
+		data, _ := json.Marshal(list)
+		SetOutput(ctx, k, string(data))
+	}
+	return err
+}
+
+func (t *MapTask) subDag() *dagTask {
+	return t.dag
+}
@@ -0,0 +1,80 @@
+package task
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMapTask(t *testing.T) {
+	d, err := CreateTaskDag(DagTaskConfig{
+		ConcurrentLimit: 2,
+		Params:          map[string]string{"suffix": "!"},
+		Tasks: []map[string]interface{}{
+			{"name": "tenants", "type": "sh", "shellcmd": "printf 'a\\nb\\nc\\n'"},
+			{"name": "export", "type": "sh", "mapOver": "{tasks.tenants.outputs.stdout}",
+				"shellcmd": "echo {index}-{item}{params.suffix}", "dependOn": []interface{}{"tenants"}},
+			// each instance has its own name
+			{"name": "static", "type": "echo", "mapOver": []interface{}{1, 2}, "echostr": "{name}:{item}"},
+			{"name": "reduce", "type": "echo", "echostr": "{tasks.export.outputs.stdout} {tasks.export.outputs.2.stdout}",
+				"dependOn": []interface{}{"export", "static"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Run(); err != nil {
+		t.Fatal(err)
+	}
+	results := map[string]TaskResult{}
+	for _, tr := range d.Result().Tasks {
+		results[tr.Name] = tr
+	}
+	export := results["export"]
+	if len(export.Tasks) != 3 || export.Tasks[1].Name != "export[1]" || export.Tasks[1].State != StateSucceeded {
+		t.Fatalf("unexpected instances %+v", export.Tasks)
+	}
+	expected := `["0-a!","1-b!","2-c!"] 2-c!`
+	if out := strings.TrimSpace(results["reduce"].Output); out != expected {
+		t.Fatalf("expect reduce output %s, got %s", expected, out)
+	}
+	static := results["static"].Tasks
+	if len(static) != 2 || strings.TrimSpace(static[1].Output) != "static[1]:2" {
+		t.Fatalf("unexpected instances %+v", static)
+	}
+}
+
+func TestMapTaskFailure(t *testing.T) {
+	d, err := CreateTaskDag(DagTaskConfig{
+		Tasks: []map[string]interface{}{
+			{"name": "m", "type": "sh", "mapOver": "[1, 2, 3]", "shellcmd": "test {item} != 2"},
+			{"name": "after", "type": "echo", "echostr": "x", "dependOn": []interface{}{"m"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Run(); err == nil {
+		t.Fatal("expect error")
+	}
+	r := d.Result()
+	states := map[string]TaskState{}
+	for _, tr := range r.Tasks {
+		states[tr.Name] = tr.State
+		for _, instance := range tr.Tasks {
+			states[instance.Name] = instance.State
+		}
+	}
+	expected := map[string]TaskState{
+		"m":     StateFailed,
+		"m[0]":  StateSucceeded,
+		"m[1]":  StateFailed,
+		"m[2]":  StateSucceeded,
+		"after": StateUpstreamFailed,
+	}
+	if !equalStates(states, expected) {
+		t.Fatalf("expect states %v, got %v", expected, states)
+	}
+	if _, err := newMapTask("sh", map[string]interface{}{"name": "m", "mapOver": 1, "shellcmd": "x"}); err == nil {
+		t.Fatal("expect error of bad mapOver")
+	}
+}
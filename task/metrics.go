@@ -0,0 +1,290 @@
+package task
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// upper bounds of buckets of task duration histograms, in seconds
+var durationBuckets = []float64{0.1, 0.5, 1, 5, 10, 30, 60, 300, 900, 1800, 3600}
+
+// Metrics collects metrics of runs of dags and writes them in the Prometheus
+// text format. Metrics are labeled by the dag name given to SetMetrics, and by
+// task name and type for task metrics
+//
+//	gotask_task_duration_seconds   histogram of durations of finished tasks,
+//	                               from the first attempt to the end
+//	gotask_tasks_total             counter of finished tasks by result, which
+//	                               is succeeded, failed or skipped
+//	gotask_task_retries_total      counter of retries
+//	gotask_tasks_queued            tasks waiting for slots of the pool
+//	gotask_pool_slots_used         slots of pools held by running tasks
+//	gotask_pool_slots              size of pools
+//
+// It's updated by events of runs, see Observer.
+type Metrics struct {
+	lock      sync.Mutex
+	durations map[taskLabels]*histogram
+	results   map[resultLabels]int
+	retries   map[taskLabels]int
+	queued    map[string]int
+	// pools of dags by names
+	pools map[string]map[string]*RunnerPool
+}
+
+type taskLabels struct {
+	dag, task, typ string
+}
+
+type resultLabels struct {
+	taskLabels
+	result string
+}
+
+type histogram struct {
+	counts []int
+	count  int
+	sum    float64
+}
+
+func (h *histogram) observe(v float64) {
+	for i, bound := range durationBuckets {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		durations: map[taskLabels]*histogram{},
+		results:   map[resultLabels]int{},
+		retries:   map[taskLabels]int{},
+		queued:    map[string]int{},
+		pools:     map[string]map[string]*RunnerPool{},
+	}
+}
+
+// SetMetrics makes later runs of the dag update m, labeled as dag name
+func (d *dagTask) SetMetrics(m *Metrics, name string) {
+	types := map[string]string{}
+	for _, info := range d.TaskInfos() {
+		types[info.Name] = info.Type
+	}
+	m.lock.Lock()
+	m.pools[name] = d.pools
+	m.lock.Unlock()
+	d.AddObserver(&metricsObserver{m: m, dag: name, types: types, starts: map[string]time.Time{}})
+}
+
+// metricsObserver updates metrics by events of a dag
+type metricsObserver struct {
+	m     *Metrics
+	dag   string
+	types map[string]string
+	// first start of running tasks
+	starts map[string]time.Time
+}
+
+func (o *metricsObserver) OnEvent(e Event) {
+	m := o.m
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	labels := taskLabels{dag: o.dag, task: e.Task, typ: o.types[e.Task]}
+	switch e.Type {
+	case EventDagStarted:
+		o.starts = map[string]time.Time{}
+		m.queued[o.dag] = 0
+	case EventTaskQueued:
+		m.queued[o.dag]++
+	case EventTaskRetried:
+		m.retries[labels]++
+		m.queued[o.dag]++
+	case EventTaskStarted:
+		m.queued[o.dag]--
+		if _, ok := o.starts[e.Task]; !ok {
+			o.starts[e.Task] = e.Time
+		}
+	case EventTaskSucceeded, EventTaskFailed, EventTaskSkipped:
+		result := strings.TrimPrefix(string(e.Type), "task_")
+		m.results[resultLabels{labels, result}]++
+		start, ok := o.starts[e.Task]
+		if !ok {
+			return
+		}
+		delete(o.starts, e.Task)
+		h := m.durations[labels]
+		if h == nil {
+			h = &histogram{counts: make([]int, len(durationBuckets))}
+			m.durations[labels] = h
+		}
+		h.observe(e.Time.Sub(start).Seconds())
+	case EventDagFinished:
+		m.queued[o.dag] = 0
+	}
+}
+
+func (l taskLabels) String() string {
+	return fmt.Sprintf(`dag=%s,task=%s,type=%s`, quoteLabel(l.dag), quoteLabel(l.task), quoteLabel(l.typ))
+}
+
+func quoteLabel(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, "\n", `\n`, -1)
+	return `"` + strings.Replace(s, `"`, `\"`, -1) + `"`
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// WriteText writes metrics in the Prometheus text format
+func (m *Metrics) WriteText(w io.Writer) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	var b strings.Builder
+	header := func(name, typ, help string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	}
+
+	header("gotask_task_duration_seconds", "histogram", "Duration of finished tasks.")
+	var tasks []taskLabels
+	for l := range m.durations {
+		tasks = append(tasks, l)
+	}
+	sortTaskLabels(tasks)
+	for _, l := range tasks {
+		h := m.durations[l]
+		for i, bound := range durationBuckets {
+			fmt.Fprintf(&b, "gotask_task_duration_seconds_bucket{%s,le=\"%s\"} %d\n", l, formatFloat(bound), h.counts[i])
+		}
+		fmt.Fprintf(&b, "gotask_task_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", l, h.count)
+		fmt.Fprintf(&b, "gotask_task_duration_seconds_sum{%s} %s\n", l, formatFloat(h.sum))
+		fmt.Fprintf(&b, "gotask_task_duration_seconds_count{%s} %d\n", l, h.count)
+	}
+
+	header("gotask_tasks_total", "counter", "Finished tasks by result.")
+	var results []resultLabels
+	for l := range m.results {
+		results = append(results, l)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].taskLabels != results[j].taskLabels {
+			return lessTaskLabels(results[i].taskLabels, results[j].taskLabels)
+		}
+		return results[i].result < results[j].result
+	})
+	for _, l := range results {
+		fmt.Fprintf(&b, "gotask_tasks_total{%s,result=%s} %d\n", l.taskLabels, quoteLabel(l.result), m.results[l])
+	}
+
+	header("gotask_task_retries_total", "counter", "Retries of tasks.")
+	tasks = tasks[:0]
+	for l := range m.retries {
+		tasks = append(tasks, l)
+	}
+	sortTaskLabels(tasks)
+	for _, l := range tasks {
+		fmt.Fprintf(&b, "gotask_task_retries_total{%s} %d\n", l, m.retries[l])
+	}
+
+	var dags []string
+	for name := range m.pools {
+		dags = append(dags, name)
+	}
+	sort.Strings(dags)
+	header("gotask_tasks_queued", "gauge", "Tasks waiting for slots of the pool.")
+	for _, name := range dags {
+		fmt.Fprintf(&b, "gotask_tasks_queued{dag=%s} %d\n", quoteLabel(name), m.queued[name])
+	}
+	header("gotask_pool_slots_used", "gauge", "Slots of pools held by running tasks.")
+	writePools(&b, "gotask_pool_slots_used", dags, m.pools, (*RunnerPool).InUse)
+	header("gotask_pool_slots", "gauge", "Size of pools.")
+	writePools(&b, "gotask_pool_slots", dags, m.pools, (*RunnerPool).Limit)
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writePools(b *strings.Builder, metric string, dags []string, pools map[string]map[string]*RunnerPool,
+	value func(*RunnerPool) int) {
+	for _, dag := range dags {
+		names := make([]string, 0, len(pools[dag]))
+		for name := range pools[dag] {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(b, "%s{dag=%s,pool=%s} %d\n", metric, quoteLabel(dag), quoteLabel(name), value(pools[dag][name]))
+		}
+	}
+}
+
+func lessTaskLabels(a, b taskLabels) bool {
+	if a.dag != b.dag {
+		return a.dag < b.dag
+	}
+	return a.task < b.task
+}
+
+func sortTaskLabels(labels []taskLabels) {
+	sort.Slice(labels, func(i, j int) bool {
+		return lessTaskLabels(labels[i], labels[j])
+	})
+}
+
+// Handler serves metrics for Prometheus to scrape
+func (m *Metrics) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		m.WriteText(w)
+	})
+}
+
+// ListenAndServe serves metrics at /metrics of addr until ctx is done
+func (m *Metrics) ListenAndServe(ctx context.Context, addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m.Handler())
+	server := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// WriteTextfile writes metrics to path for the textfile collector of the
+// node exporter, the file is replaced atomically
+func (m *Metrics) WriteTextfile(path string) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := m.WriteText(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	// TempFile creates files readable by the owner only
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
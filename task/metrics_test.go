@@ -0,0 +1,63 @@
+package task
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	d, err := CreateTaskDag(DagTaskConfig{
+		ConcurrentLimit: 2,
+		Mode:            ContinueOnError,
+		Tasks: []map[string]interface{}{
+			{"name": "a", "type": "echo", "echostr": "a"},
+			{"name": "b", "type": "sh", "shellcmd": "exit 1", "retries": 2, "dependOn": []interface{}{"a"}},
+			{"name": "c", "type": "echo", "echostr": "c", "dependOn": []interface{}{"b"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.SetLogger(nil)
+	d.SetOutputSink(NewMemorySink())
+	m := NewMetrics()
+	d.SetMetrics(m, "etl")
+	d.Run()
+	d.Run()
+
+	server := httptest.NewServer(m.Handler())
+	defer server.Close()
+	resp, err := server.Client().Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	text := string(data)
+	for _, line := range []string{
+		`gotask_task_duration_seconds_bucket{dag="etl",task="a",type="echo",le="+Inf"} 2`,
+		`gotask_task_duration_seconds_count{dag="etl",task="b",type="sh"} 2`,
+		`gotask_tasks_total{dag="etl",task="a",type="echo",result="succeeded"} 2`,
+		`gotask_tasks_total{dag="etl",task="b",type="sh",result="failed"} 2`,
+		`gotask_tasks_total{dag="etl",task="c",type="echo",result="skipped"} 2`,
+		`gotask_task_retries_total{dag="etl",task="b",type="sh"} 4`,
+		`gotask_tasks_queued{dag="etl"} 0`,
+		`gotask_pool_slots_used{dag="etl",pool="default"} 0`,
+		`gotask_pool_slots{dag="etl",pool="default"} 2`,
+	} {
+		if !strings.Contains(text, line+"\n") {
+			t.Fatalf("%s not found in\n%s", line, text)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "gotask.prom")
+	if err := m.WriteTextfile(path); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := ioutil.ReadFile(path); err != nil || string(data) != text {
+		t.Fatalf("unexpected textfile %s, %v", data, err)
+	}
+}
@@ -0,0 +1,69 @@
+package task
+
+import (
+	"context"
+	"io"
+	"os"
+	"sync"
+)
+
+type outputKey struct{}
+
+// Output returns the writer a task should write its output to
+func Output(ctx context.Context) io.Writer {
+	if w, ok := ctx.Value(outputKey{}).(io.Writer); ok {
+		return w
+	}
+	return os.Stdout
+}
+
+func withOutput(ctx context.Context, w io.Writer) context.Context {
+	return context.WithValue(ctx, outputKey{}, w)
+}
+
+type outputsKey struct{}
+
+// SetOutput publishes a named output of the running task, tasks depending on
+// it can reference the value as {tasks.NAME.outputs.KEY} in their configs
+func SetOutput(ctx context.Context, key, value string) {
+	if t, ok := ctx.Value(outputsKey{}).(*task); ok {
+		t.setOutput(key, value)
+	}
+}
+
+func withOutputs(ctx context.Context, t *task) context.Context {
+	return context.WithValue(ctx, outputsKey{}, t)
+}
+
+var defaultTailSize = 4096
+
+// tailBuffer keeps the last bytes written to it
+type tailBuffer struct {
+	lock sync.Mutex
+	size int
+	buf  []byte
+}
+
+func newTailBuffer(size int) *tailBuffer {
+	return &tailBuffer{size: size}
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	n := len(p)
+	if len(p) > b.size {
+		p = p[len(p)-b.size:]
+	}
+	if drop := len(b.buf) + len(p) - b.size; drop > 0 {
+		b.buf = b.buf[drop:]
+	}
+	b.buf = append(b.buf, p...)
+	return n, nil
+}
+
+func (b *tailBuffer) String() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return string(b.buf)
+}
@@ -0,0 +1,350 @@
+package task
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/zxdvd/go-libs/dag"
+)
+
+// PlannedTask is a task of the plan with variables of the run resolved,
+// references to outputs of upstream tasks are kept as is. Values of secret
+// fields like uri, env and password are redacted, strings referencing
+// environment variables are not resolved, and internal fields starting with _
+// are dropped.
+type PlannedTask struct {
+	Name     string                 `json:"name"`
+	Type     string                 `json:"type"`
+	DependOn []string               `json:"dependOn,omitempty"`
+	Config   map[string]interface{} `json:"config"`
+	// fields of Config referencing variables, as they are in the workflow
+	Templates map[string]interface{} `json:"templates,omitempty"`
+	// when evaluates to false, the task will be skipped
+	Skip bool `json:"skip,omitempty"`
+}
+
+// Plan tells what a run would do without running anything. Tasks of a wave
+// depend only on tasks of earlier waves and can run at the same time, slots
+// taken by tasks of a wave don't exceed the size of any pool. Tasks are in
+// the order they would start, see the priority of tasks.
+type Plan struct {
+	RunID           string `json:"runId"`
+	ConcurrentLimit int    `json:"concurrentLimit"`
+	// sizes of named pools
+	Pools map[string]int  `json:"pools,omitempty"`
+	Waves [][]PlannedTask `json:"waves"`
+}
+
+// Plan resolves variables and validates configs of all tasks, it returns
+// errors of all bad tasks
+func (d *dagTask) Plan() (*Plan, error) {
+	return d.plan(selectAll)
+}
+
+// PlanTask is like Plan but for the named task and tasks it depends on
+func (d *dagTask) PlanTask(name string) (*Plan, error) {
+	selected, err := d.selectTask(name)
+	if err != nil {
+		return nil, err
+	}
+	return d.plan(selected)
+}
+
+func (d *dagTask) plan(selected func(*task) bool) (*Plan, error) {
+	vars := d.newRunVars()
+	// depth of a task is the length of the longest path to it
+	depth := map[*task]int{}
+	planned := map[*task]*PlannedTask{}
+	var tasks []*task
+	var levels [][]*task
+	var errs MultiError
+	// Iterate puts a task after all tasks it depends on
+	d.Iterate(func(node dag.Node) (bool, error) {
+		t := node.(*task)
+		if !selected(t) {
+			return true, nil
+		}
+		pt, err := t.plan(vars)
+		if err != nil {
+			errs = append(errs, errors.Wrapf(err, "task %s", t.Name()))
+			return true, nil
+		}
+		level := 0
+		for _, dep := range t.dependOn {
+			if depth[dep]+1 > level {
+				level = depth[dep] + 1
+			}
+		}
+		depth[t] = level
+		for len(levels) <= level {
+			levels = append(levels, nil)
+		}
+		levels[level] = append(levels[level], t)
+		planned[t] = pt
+		tasks = append(tasks, t)
+		return true, nil
+	})
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	// tasks of a level are in the order they are started
+	setPathLens(tasks, downstreams(tasks))
+	for _, level := range levels {
+		sort.SliceStable(level, func(i, j int) bool {
+			return level[i].before(level[j])
+		})
+	}
+	p := &Plan{
+		RunID:           vars.runID,
+		ConcurrentLimit: d.pools[DefaultPool].limit,
+	}
+	for name, pool := range d.pools {
+		if name != DefaultPool {
+			if p.Pools == nil {
+				p.Pools = map[string]int{}
+			}
+			p.Pools[name] = pool.limit
+		}
+	}
+	for _, level := range levels {
+		var wave []PlannedTask
+		used := map[string]int{}
+		for _, t := range level {
+			uses := t.pools
+			if len(wave) > 0 && !fitPools(uses, used) {
+				p.Waves = append(p.Waves, wave)
+				wave = nil
+				used = map[string]int{}
+			}
+			wave = append(wave, *planned[t])
+			for _, u := range uses {
+				used[u.name] += u.weight
+			}
+		}
+		if len(wave) > 0 {
+			p.Waves = append(p.Waves, wave)
+		}
+	}
+	return p, nil
+}
+
+// fitPools tells whether pools have enough slots for uses besides used
+func fitPools(uses []poolUse, used map[string]int) bool {
+	for _, u := range uses {
+		if u.pool != nil && used[u.name]+u.weight > u.pool.limit {
+			return false
+		}
+	}
+	return true
+}
+
+// plan resolves the config of t by vars and creates the task from it to
+// validate it, t is not changed
+func (t *task) plan(vars *runVars) (*PlannedTask, error) {
+	conf, err := t.configExpander(vars, false).config(t.config)
+	if err != nil {
+		return nil, err
+	}
+	if t.templated {
+		if _, err := createTask(t.typ, conf); err != nil {
+			return nil, err
+		}
+	}
+	if t.runnerTemplated {
+		// fields referencing outputs are checked when the task runs
+		resolved := true
+		for _, k := range runnerFields {
+			resolved = resolved && !valueHasTemplateVars(conf[k])
+		}
+		errs := &ConfigError{Task: t.Name()}
+		if parseRunnerFields(conf, errs); resolved && errs.err() != nil {
+			return nil, errs.err()
+		}
+	}
+	pt := &PlannedTask{
+		Name:     t.Name(),
+		Type:     t.typ,
+		DependOn: make([]string, len(t.dependOn)),
+		Config:   map[string]interface{}{},
+	}
+	for i, dep := range t.dependOn {
+		pt.DependOn[i] = dep.Name()
+	}
+	for k, raw := range t.config {
+		if strings.HasPrefix(k, "_") {
+			continue
+		}
+		pt.Config[k] = safeValue(k, raw, conf[k])
+		if isSecretKey(k) || untemplatedFields[k] || !valueHasTemplateVars(raw) {
+			continue
+		}
+		if pt.Templates == nil {
+			pt.Templates = map[string]interface{}{}
+		}
+		pt.Templates[k] = raw
+	}
+	if t.when == nil {
+		return pt, nil
+	}
+	// it's decided when the run starts unless it needs outputs of tasks
+	for _, name := range exprVars(t.when) {
+		if _, _, ok := parseOutputVar(name); ok {
+			return pt, nil
+		}
+	}
+	ok, err := evalBool(t.when, t.lookupVars(vars, false))
+	if err != nil {
+		return nil, errors.Wrap(err, "bad when")
+	}
+	pt.Skip = !ok
+	return pt, nil
+}
+
+// WriteText writes the plan as readable text
+func (p *Plan) WriteText(w io.Writer) error {
+	fmt.Fprintf(w, "run id: %s, concurrent limit: %d\n", p.RunID, p.ConcurrentLimit)
+	if len(p.Pools) > 0 {
+		names := make([]string, 0, len(p.Pools))
+		for name := range p.Pools {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Fprint(w, "pools:")
+		for _, name := range names {
+			fmt.Fprintf(w, " %s=%d", name, p.Pools[name])
+		}
+		fmt.Fprintln(w)
+	}
+	for i, wave := range p.Waves {
+		fmt.Fprintf(w, "\nwave %d:\n", i+1)
+		for _, pt := range wave {
+			fmt.Fprintf(w, "  %s (%s)", pt.Name, pt.Type)
+			if len(pt.DependOn) > 0 {
+				fmt.Fprintf(w, " after %v", pt.DependOn)
+			}
+			if pt.Skip {
+				fmt.Fprint(w, ", skipped since when is false")
+			}
+			fmt.Fprintln(w)
+			keys := make([]string, 0, len(pt.Config))
+			for k := range pt.Config {
+				switch k {
+				case "name", "type", "dependOn":
+				default:
+					keys = append(keys, k)
+				}
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				fmt.Fprintf(w, "      %s: %v", k, pt.Config[k])
+				if tmpl, ok := pt.Templates[k]; ok && fmt.Sprint(tmpl) != fmt.Sprint(pt.Config[k]) {
+					fmt.Fprintf(w, " (from %v)", tmpl)
+				}
+				if _, err := fmt.Fprintln(w); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// redacted replaces values of secret fields in plans
+const redacted = "******"
+
+var secretKeyParts = []string{"password", "passwd", "secret", "token", "apikey", "api_key", "authorization", "credential"}
+
+// isSecretKey tells whether values of the config field may be credentials
+func isSecretKey(key string) bool {
+	k := strings.ToLower(key)
+	switch k {
+	case "env", "uri", "dsn":
+		return true
+	}
+	for _, part := range secretKeyParts {
+		if strings.Contains(k, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// safeValue returns the resolved value of field key for printing, values of
+// secret fields are redacted and strings referencing environment variables
+// are kept as raw
+func safeValue(key string, raw, resolved interface{}) interface{} {
+	if isSecretKey(key) {
+		return redactValue(resolved)
+	}
+	switch raw := raw.(type) {
+	case string:
+		if referencesEnv(raw) {
+			return raw
+		}
+	case map[string]interface{}:
+		m, _ := resolved.(map[string]interface{})
+		safe := make(map[string]interface{}, len(raw))
+		for k, v := range raw {
+			safe[k] = safeValue(k, v, m[k])
+		}
+		return safe
+	case []interface{}:
+		list, _ := resolved.([]interface{})
+		safe := make([]interface{}, len(raw))
+		for i, v := range raw {
+			var item interface{}
+			if i < len(list) {
+				item = list[i]
+			}
+			safe[i] = safeValue(key, v, item)
+		}
+		return safe
+	}
+	return resolved
+}
+
+// redactValue redacts a value, keys of mappings are kept
+func redactValue(v interface{}) interface{} {
+	if m, ok := v.(map[string]interface{}); ok {
+		safe := make(map[string]interface{}, len(m))
+		for k, item := range m {
+			safe[k] = redactValue(item)
+		}
+		return safe
+	}
+	if v == nil || v == "" {
+		return v
+	}
+	return redacted
+}
+
+// referencesEnv tells whether s references environment variables
+func referencesEnv(s string) bool {
+	for _, sub := range patTemplateVar.FindAllStringSubmatch(s, -1) {
+		if strings.HasPrefix(sub[1], "env.") {
+			return true
+		}
+	}
+	return false
+}
+
+// valueHasTemplateVars is like hasTemplateVars for strings in config values
+func valueHasTemplateVars(v interface{}) bool {
+	found := false
+	expandValue(v, func(name, format string) (string, bool, error) {
+		found = found || isTemplateVar(name)
+		return "", false, nil
+	})
+	return found
+}
+
+// WriteJSON writes the plan as indented json
+func (p *Plan) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(p)
+}
@@ -0,0 +1,133 @@
+package task
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPlan(t *testing.T) {
+	d, err := CreateTaskDag(DagTaskConfig{
+		ConcurrentLimit: 2,
+		Params:          map[string]string{"env": "dev"},
+		Tasks: []map[string]interface{}{
+			{"name": "a", "type": "echo", "echostr": "a"},
+			{"name": "b", "type": "echo", "echostr": "b"},
+			{"name": "c", "type": "echo", "echostr": "c"},
+			{"name": "d", "type": "sh", "shellcmd": "echo {params.env} {tasks.a.outputs.x}",
+				"dependOn": []interface{}{"a"}},
+			{"name": "e", "type": "echo", "echostr": "e", "when": "params.env == 'prod'",
+				"dependOn": []interface{}{"d", "b"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.SetRunID("plan")
+	p, err := d.Plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var waves [][]string
+	for _, wave := range p.Waves {
+		var names []string
+		for _, pt := range wave {
+			names = append(names, pt.Name)
+		}
+		waves = append(waves, names)
+	}
+	expected := "[[a b] [c] [d] [e]]"
+	if got := fmt.Sprint(waves); got != expected {
+		t.Fatalf("expect waves %s, got %s", expected, got)
+	}
+	d1 := p.Waves[2][0]
+	if cmd := d1.Config["shellcmd"]; cmd != "echo dev {tasks.a.outputs.x}" {
+		t.Fatalf("unexpected shellcmd %v", cmd)
+	}
+	if !p.Waves[3][0].Skip {
+		t.Fatal("e should be skipped")
+	}
+	var buf bytes.Buffer
+	p.WriteText(&buf)
+	if !strings.Contains(buf.String(), "run id: plan") || !strings.Contains(buf.String(), "skipped") {
+		t.Fatalf("unexpected plan %s", buf.String())
+	}
+	// nothing is run
+	for name, state := range taskStates(d) {
+		if state != StatePending {
+			t.Fatalf("task %s should not run, got %s", name, state)
+		}
+	}
+
+	p, err = d.PlanTask("d")
+	if err != nil || len(p.Waves) != 2 {
+		t.Fatalf("unexpected plan of d %v, %v", p, err)
+	}
+}
+
+func TestPlanErrors(t *testing.T) {
+	d, err := CreateTaskDag(DagTaskConfig{
+		Tasks: []map[string]interface{}{
+			{"name": "a", "type": "echo", "echostr": "{params.x}"},
+			{"name": "b", "type": "echo", "echostr": "b"},
+			{"name": "c", "type": "echo", "echostr": "c", "when": "params.y"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = d.Plan()
+	errs, ok := err.(MultiError)
+	if !ok || len(errs) != 2 {
+		t.Fatalf("expect errors of a and c, got %v", err)
+	}
+}
+
+func TestPlanRedaction(t *testing.T) {
+	t.Setenv("GOTASK_TEST_SECRET", "s3cret")
+	d, err := CreateTaskDag(DagTaskConfig{
+		Params: map[string]string{"host": "db.local"},
+		Tasks: []map[string]interface{}{
+			{"name": "a", "type": "sh", "shellcmd": "curl -u {env.GOTASK_TEST_SECRET} {params.host}",
+				"env": map[string]interface{}{"TOKEN": "{env.GOTASK_TEST_SECRET}"}},
+			{"name": "b", "type": "sql", "dialect": "postgres", "uri": "postgres://u:pw@{params.host}/db",
+				"sql": "select '{ds}'", "dependOn": []interface{}{"a"}},
+			{"name": "c", "type": "http", "url": "http://{params.host}/",
+				"headers": map[string]interface{}{"Authorization": "Bearer x", "Accept": "*/*"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.SetRunDate(time.Date(2024, 1, 2, 0, 0, 0, 0, time.Local))
+	p, err := d.Plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	p.WriteText(&buf)
+	text := buf.String()
+	if strings.Contains(text, "s3cret") || strings.Contains(text, "pw@") || strings.Contains(text, "Bearer") {
+		t.Fatalf("secrets in plan %s", text)
+	}
+	for _, s := range []string{
+		"shellcmd: curl -u {env.GOTASK_TEST_SECRET} {params.host}\n",
+		"env: map[TOKEN:******]\n",
+		"uri: ******\n",
+		"sql: select '2024-01-02' (from select '{ds}')\n",
+		"headers: map[Accept:*/* Authorization:******]\n",
+		"url: http://db.local/ (from http://{params.host}/)\n",
+	} {
+		if !strings.Contains(text, s) {
+			t.Errorf("expect %q in plan %s", s, text)
+		}
+	}
+	// planning doesn't change tasks
+	for _, node := range d.Nodes() {
+		if node.(*task).vars != nil {
+			t.Fatalf("vars of task %s are set", node.(*task).Name())
+		}
+	}
+}
@@ -0,0 +1,333 @@
+package task
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultPool is the name of the pool of size ConcurrentLimit, every task
+// takes one slot of it unless pools of the task say otherwise
+const DefaultPool = "default"
+
+// RunnerPool limits the number of slots taken by running tasks. Waiters are
+// served in order so that tasks taking many slots are not starved.
+type RunnerPool struct {
+	limit   int
+	lock    sync.Mutex
+	used    int
+	waiters []*poolWaiter
+
+	// notified when slots may be free, see watch
+	watchers map[chan struct{}]bool
+}
+
+type poolWaiter struct {
+	n     int
+	ready chan struct{}
+}
+
+func NewRunnerPool(n int) *RunnerPool {
+	return &RunnerPool{limit: n}
+}
+
+func (p *RunnerPool) Get() {
+	p.Acquire(context.Background(), 1)
+}
+
+// GetContext is like Get but gives up when ctx is done
+func (p *RunnerPool) GetContext(ctx context.Context) error {
+	return p.Acquire(ctx, 1)
+}
+
+func (p *RunnerPool) Put() {
+	p.Release(1)
+}
+
+// Acquire takes n slots, it waits until they are free or ctx is done
+func (p *RunnerPool) Acquire(ctx context.Context, n int) error {
+	p.lock.Lock()
+	if n > p.limit {
+		p.lock.Unlock()
+		return fmt.Errorf("%d slots exceed the pool size %d", n, p.limit)
+	}
+	if len(p.waiters) == 0 && p.used+n <= p.limit {
+		p.used += n
+		p.lock.Unlock()
+		return nil
+	}
+	w := &poolWaiter{n: n, ready: make(chan struct{})}
+	p.waiters = append(p.waiters, w)
+	p.lock.Unlock()
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	select {
+	case <-w.ready:
+		// got the slots while giving up
+		p.used -= n
+	default:
+		for i, other := range p.waiters {
+			if other == w {
+				p.waiters = append(p.waiters[:i], p.waiters[i+1:]...)
+				break
+			}
+		}
+	}
+	p.notify()
+	return ctx.Err()
+}
+
+// TryAcquire takes n slots if they are free and nobody is waiting for them
+func (p *RunnerPool) TryAcquire(n int) (bool, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if n > p.limit {
+		return false, fmt.Errorf("%d slots exceed the pool size %d", n, p.limit)
+	}
+	if len(p.waiters) > 0 || p.used+n > p.limit {
+		return false, nil
+	}
+	p.used += n
+	return true, nil
+}
+
+// Release puts back n slots taken by Acquire
+func (p *RunnerPool) Release(n int) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.used -= n
+	p.notify()
+}
+
+// untake puts back n slots taken by TryAcquire without notifying watchers,
+// the dispatcher trying it would be woken up again and again otherwise
+func (p *RunnerPool) untake(n int) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.used -= n
+	p.wakeWaiters()
+}
+
+// notify wakes up waiters and watchers
+func (p *RunnerPool) notify() {
+	p.wakeWaiters()
+	for ch := range p.watchers {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// wakeWaiters wakes up waiters in order while there are enough free slots
+func (p *RunnerPool) wakeWaiters() {
+	for len(p.waiters) > 0 && p.used+p.waiters[0].n <= p.limit {
+		w := p.waiters[0]
+		p.waiters = p.waiters[1:]
+		p.used += w.n
+		close(w.ready)
+	}
+}
+
+// watch makes ch receive a value when slots are released, until unwatch is
+// called
+func (p *RunnerPool) watch(ch chan struct{}) (unwatch func()) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.watchers == nil {
+		p.watchers = map[chan struct{}]bool{}
+	}
+	p.watchers[ch] = true
+	return func() {
+		p.lock.Lock()
+		defer p.lock.Unlock()
+		delete(p.watchers, ch)
+	}
+}
+
+// InUse returns the number of slots taken
+func (p *RunnerPool) InUse() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.used
+}
+
+// Limit returns the size of the pool
+func (p *RunnerPool) Limit() int {
+	return p.limit
+}
+
+// poolUse is the slots of a pool taken by a task
+type poolUse struct {
+	name   string
+	weight int
+	// nil until the pool is inherited from the parent dag
+	pool *RunnerPool
+}
+
+// newPoolUses reads pools of a task config, which is a pool name, a list of
+// names, or a mapping of names to numbers of slots, like
+//
+//	pools: db
+//	pools: [db, api]
+//	pools: {cpu: 4, default: 2}
+//
+// The task takes one slot of each named pool, and one slot of the default
+// pool unless the default pool is listed.
+func newPoolUses(conf map[string]interface{}) ([]poolUse, error) {
+	weights := map[string]int{}
+	switch v := conf["pools"].(type) {
+	case nil:
+	case map[string]interface{}:
+		for name, w := range v {
+			n, ok := toInt(w)
+			if !ok || n < 1 {
+				return nil, fmt.Errorf("slots of pool %s should be a positive integer, got %v", name, w)
+			}
+			weights[name] = n
+		}
+	default:
+		names, err := toStringList(v)
+		if err != nil {
+			return nil, errors.Wrap(err, "expect a pool name, a list or a mapping")
+		}
+		for _, name := range names {
+			weights[name.(string)] = 1
+		}
+	}
+	if _, ok := weights[DefaultPool]; !ok {
+		weights[DefaultPool] = 1
+	}
+	uses := make([]poolUse, 0, len(weights))
+	for name, w := range weights {
+		uses = append(uses, poolUse{name: name, weight: w})
+	}
+	// pools are always acquired in the same order to avoid deadlocks
+	sort.Slice(uses, func(i, j int) bool {
+		return uses[i].name < uses[j].name
+	})
+	return uses, nil
+}
+
+// checkPools checks that pools used by a task exist and are large enough
+func checkPools(uses []poolUse, pools map[string]*RunnerPool, inherit bool) error {
+	for _, u := range uses {
+		pool, ok := pools[u.name]
+		if !ok {
+			if inherit {
+				continue
+			}
+			return fmt.Errorf("unknown pool %s", u.name)
+		}
+		if u.weight > pool.limit {
+			return fmt.Errorf("%d slots of pool %s exceed its size %d", u.weight, u.name, pool.limit)
+		}
+	}
+	return nil
+}
+
+// bindPools sets pools of uses by names
+func bindPools(uses []poolUse, pools map[string]*RunnerPool) {
+	for i := range uses {
+		if pool, ok := pools[uses[i].name]; ok {
+			uses[i].pool = pool
+		}
+	}
+}
+
+// poolSlot is the slots of pools held by a running task, the task may
+// release them while waiting, see Sleep
+type poolSlot struct {
+	uses []poolUse
+	held bool
+	// all pools of the dag, shared with sub dags
+	pools map[string]*RunnerPool
+}
+
+func (s *poolSlot) acquire(ctx context.Context) error {
+	if s.held {
+		return nil
+	}
+	for i, u := range s.uses {
+		if u.pool == nil {
+			s.releaseUses(s.uses[:i])
+			return fmt.Errorf("pool %s not found", u.name)
+		}
+		if err := u.pool.Acquire(ctx, u.weight); err != nil {
+			s.releaseUses(s.uses[:i])
+			return err
+		}
+	}
+	s.held = true
+	return nil
+}
+
+// tryAcquire takes slots of all pools if they are free, pools in blocked are
+// not tried, and the pool lacking slots is added to it
+func (s *poolSlot) tryAcquire(blocked map[*RunnerPool]bool) (bool, error) {
+	if s.held {
+		return true, nil
+	}
+	for _, u := range s.uses {
+		if u.pool == nil {
+			return false, fmt.Errorf("pool %s not found", u.name)
+		}
+		if blocked[u.pool] {
+			return false, nil
+		}
+	}
+	for i, u := range s.uses {
+		ok, err := u.pool.TryAcquire(u.weight)
+		if err != nil || !ok {
+			for _, taken := range s.uses[:i] {
+				taken.pool.untake(taken.weight)
+			}
+			if !ok {
+				blocked[u.pool] = true
+			}
+			return false, err
+		}
+	}
+	s.held = true
+	return true, nil
+}
+
+func (s *poolSlot) releaseUses(uses []poolUse) {
+	for i := len(uses) - 1; i >= 0; i-- {
+		uses[i].pool.Release(uses[i].weight)
+	}
+}
+
+func (s *poolSlot) release() {
+	if s.held {
+		s.releaseUses(s.uses)
+	}
+	s.held = false
+}
+
+type poolSlotKey struct{}
+
+func withPoolSlot(ctx context.Context, slot *poolSlot) context.Context {
+	return context.WithValue(ctx, poolSlotKey{}, slot)
+}
+
+// setPools makes the dag share pools of the parent dag with the same names
+func (d *dagTask) setPools(parent map[string]*RunnerPool) {
+	for name, pool := range parent {
+		if _, ok := d.pools[name]; ok || d.inheritPools {
+			d.pools[name] = pool
+		}
+	}
+	for _, node := range d.Nodes() {
+		t := node.(*task)
+		bindPools(t.pools, d.pools)
+	}
+}
@@ -0,0 +1,168 @@
+package task
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRunnerPool(t *testing.T) {
+	p := NewRunnerPool(3)
+	ctx := context.Background()
+	if err := p.Acquire(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Acquire(ctx, 4); err == nil {
+		t.Fatal("expect error of too many slots")
+	}
+	got := make(chan int, 2)
+	go func() {
+		p.Acquire(ctx, 2)
+		got <- 2
+	}()
+	time.Sleep(20 * time.Millisecond)
+	// waiters are served in order even if there are enough slots
+	go func() {
+		p.Acquire(ctx, 1)
+		got <- 1
+	}()
+	time.Sleep(20 * time.Millisecond)
+	if p.InUse() != 2 || len(got) != 0 {
+		t.Fatalf("unexpected state, %d slots taken", p.InUse())
+	}
+	p.Release(2)
+	if first, second := <-got, <-got; first+second != 3 || p.InUse() != 3 {
+		t.Fatalf("unexpected slots %d %d", first, second)
+	}
+
+	// canceled waiters don't block later ones
+	cctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if err := p.Acquire(cctx, 3); err != context.DeadlineExceeded {
+		t.Fatalf("unexpected error %v", err)
+	}
+	p.Release(1)
+	if err := p.Acquire(ctx, 1); err != nil || p.InUse() != 3 {
+		t.Fatalf("unexpected state %v, %d slots taken", err, p.InUse())
+	}
+}
+
+func TestPoolSlotTryAcquire(t *testing.T) {
+	a, b := NewRunnerPool(2), NewRunnerPool(1)
+	b.Get()
+	wake := make(chan struct{}, 1)
+	defer a.watch(wake)()
+	slot := &poolSlot{uses: []poolUse{{name: "a", weight: 1, pool: a}, {name: "b", weight: 1, pool: b}}}
+	blocked := map[*RunnerPool]bool{}
+	if ok, err := slot.tryAcquire(blocked); ok || err != nil || !blocked[b] {
+		t.Fatalf("unexpected result %v %v %v", ok, err, blocked)
+	}
+	// slots taken before the blocked pool are put back silently, or the
+	// dispatcher watching them would spin
+	if a.InUse() != 0 || len(wake) != 0 {
+		t.Fatalf("unexpected state, %d slots taken, %d wakes", a.InUse(), len(wake))
+	}
+	b.Put()
+	if ok, err := slot.tryAcquire(map[*RunnerPool]bool{}); !ok || err != nil || a.InUse() != 1 {
+		t.Fatalf("unexpected result %v %v", ok, err)
+	}
+	slot.release()
+	if a.InUse() != 0 || b.InUse() != 0 || len(wake) != 1 {
+		t.Fatalf("unexpected state after release")
+	}
+}
+
+// probe records the max number of probes running at the same time by group
+type probe struct {
+	lock    sync.Mutex
+	running map[string]int
+	max     map[string]int
+}
+
+var probes = &probe{running: map[string]int{}, max: map[string]int{}}
+
+type ProbeConfig struct {
+	Groups []string `task:"groups"`
+}
+
+func init() {
+	RegisterConfig("probe", &ProbeConfig{}, func(name string, config interface{}) (Task, error) {
+		return &probeTask{baseTask: baseTask{name: name}, groups: config.(*ProbeConfig).Groups}, nil
+	})
+}
+
+type probeTask struct {
+	baseTask
+	groups []string
+}
+
+func (t *probeTask) Run(ctx context.Context) error {
+	probes.lock.Lock()
+	for _, g := range t.groups {
+		probes.running[g]++
+		if probes.running[g] > probes.max[g] {
+			probes.max[g] = probes.running[g]
+		}
+	}
+	probes.lock.Unlock()
+	time.Sleep(30 * time.Millisecond)
+	probes.lock.Lock()
+	for _, g := range t.groups {
+		probes.running[g]--
+	}
+	probes.lock.Unlock()
+	return nil
+}
+
+func TestPools(t *testing.T) {
+	probeConf := func(name string, pools interface{}, groups ...interface{}) map[string]interface{} {
+		return map[string]interface{}{"name": name, "type": "probe", "pools": pools, "groups": groups}
+	}
+	d, err := CreateTaskDag(DagTaskConfig{
+		ConcurrentLimit: 4,
+		Pools:           map[string]int{"db": 1, "cpu": 4},
+		Tasks: []map[string]interface{}{
+			probeConf("db1", "db", "db"),
+			probeConf("db2", []interface{}{"db"}, "db"),
+			probeConf("heavy1", map[string]interface{}{"cpu": 3}, "cpu"),
+			probeConf("heavy2", map[string]interface{}{"cpu": 3}, "cpu"),
+			// it may run with a heavy task, 3+1 slots of cpu are free
+			probeConf("both", []interface{}{"db", "cpu"}, "db"),
+			{"name": "each", "type": "probe", "mapOver": []interface{}{"a", "b", "c"}, "pools": "db",
+				"groups": []interface{}{"db"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.SetLogger(nil)
+	if err := d.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if probes.max["db"] != 1 || probes.max["cpu"] != 1 {
+		t.Fatalf("unexpected concurrency %v", probes.max)
+	}
+
+	plan, err := d.Plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// db1 | db2 heavy1 | heavy2 both each, instances of each are not planned
+	if len(plan.Waves) != 3 || plan.Pools["db"] != 1 {
+		t.Fatalf("unexpected plan %+v", plan)
+	}
+
+	bad := []map[string]interface{}{
+		{"name": "a", "type": "echo", "echostr": "a", "pools": "unknown"},
+		{"name": "a", "type": "echo", "echostr": "a", "pools": map[string]interface{}{"db": 2}},
+		{"name": "a", "type": "echo", "echostr": "a", "pools": map[string]interface{}{"db": 0}},
+	}
+	for _, conf := range bad {
+		_, err := CreateTaskDag(DagTaskConfig{Pools: map[string]int{"db": 1},
+			Tasks: []map[string]interface{}{conf}})
+		if err == nil {
+			t.Fatalf("expect error of %v", conf)
+		}
+	}
+}
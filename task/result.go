@@ -0,0 +1,143 @@
+package task
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/zxdvd/go-libs/dag"
+)
+
+// TaskResult is the result of a task in the last run
+type TaskResult struct {
+	Name     string    `json:"name"`
+	Type     string    `json:"type"`
+	State    TaskState `json:"state"`
+	TimedOut bool      `json:"timedOut,omitempty"`
+	// succeeded in the resumed run
+	Restored bool          `json:"restored,omitempty"`
+	Attempts int           `json:"attempts"`
+	Start    time.Time     `json:"start"`
+	End      time.Time     `json:"end"`
+	Duration time.Duration `json:"duration"`
+	// tail of the output
+	Output string `json:"output,omitempty"`
+	// values published by SetOutput
+	Outputs map[string]string `json:"outputs,omitempty"`
+	Error   string            `json:"error,omitempty"`
+	// tasks of SubDagTask or instances of MapTask
+	Tasks []TaskResult `json:"tasks,omitempty"`
+}
+
+// RunResult is the report of the last run of a dag
+type RunResult struct {
+	RunID     string        `json:"runId,omitempty"`
+	Succeeded bool          `json:"succeeded"`
+	Start     time.Time     `json:"start"`
+	End       time.Time     `json:"end"`
+	Duration  time.Duration `json:"duration"`
+	Tasks     []TaskResult  `json:"tasks"`
+}
+
+// Result returns the report of the last run, tasks are ordered so that a task
+// is always after the tasks it depends on. Only tasks selected by the last
+// run are reported, see RunTask.
+func (d *dagTask) Result() *RunResult {
+	r := &RunResult{
+		Succeeded: true,
+		Start:     d.start,
+		End:       d.end,
+		Duration:  d.end.Sub(d.start),
+	}
+	if d.vars != nil {
+		r.RunID = d.vars.runID
+	}
+	d.Iterate(func(node dag.Node) (bool, error) {
+		t := node.(*task)
+		if d.selected != nil && !d.selected(t) {
+			return true, nil
+		}
+		tr := TaskResult{
+			Name:     t.Name(),
+			Type:     t.typ,
+			State:    t.state,
+			TimedOut: t.timedOut,
+			Restored: t.restored,
+			Attempts: t.attempts,
+			Start:    t.start,
+			End:      t.end,
+			Duration: t.end.Sub(t.start),
+			Output:   t.output.String(),
+			Outputs:  t.copyOutputs(),
+		}
+		if t.err != nil {
+			tr.Error = t.err.Error()
+		}
+		if sub, ok := t.Task.(interface{ subDag() *dagTask }); ok && sub.subDag() != nil {
+			tr.Tasks = sub.subDag().Result().Tasks
+		}
+		// skipped tasks, like those with a false when condition, don't fail
+		// the run
+		switch t.state {
+		case StateFailed, StateCancelled, StateUpstreamFailed:
+			r.Succeeded = false
+		}
+		if t.timedOut {
+			r.Succeeded = false
+		}
+		r.Tasks = append(r.Tasks, tr)
+		return true, nil
+	})
+	return r
+}
+
+// States returns states of tasks by their names
+func (r *RunResult) States() map[string]TaskState {
+	states := make(map[string]TaskState, len(r.Tasks))
+	for _, tr := range r.Tasks {
+		states[tr.Name] = tr.State
+	}
+	return states
+}
+
+// WriteTable writes the result as a table for terminals
+func (r *RunResult) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TASK\tTYPE\tSTATE\tATTEMPTS\tSTART\tDURATION\tERROR")
+	writeRows(tw, "", r.Tasks)
+	return tw.Flush()
+}
+
+// writeRows writes a row for each task, tasks of sub dags are named like
+// PARENT/TASK
+func writeRows(w io.Writer, prefix string, tasks []TaskResult) {
+	for _, t := range tasks {
+		state := string(t.State)
+		if t.TimedOut {
+			state += " (timed out)"
+		}
+		if t.Restored {
+			state += " (resumed)"
+		}
+		start, duration := "-", "-"
+		if !t.Start.IsZero() {
+			start = t.Start.Format("15:04:05")
+			duration = t.Duration.Round(time.Millisecond).String()
+		}
+		// only the first line of error to keep the table readable
+		errmsg := strings.SplitN(t.Error, "\n", 2)[0]
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\t%s\n",
+			prefix+t.Name, t.Type, state, t.Attempts, start, duration, errmsg)
+		writeRows(w, prefix+t.Name+"/", t.Tasks)
+	}
+}
+
+// WriteJSON writes the result as indented json
+func (r *RunResult) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
@@ -0,0 +1,102 @@
+package task
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestRunResult(t *testing.T) {
+	d, err := CreateTaskDag(DagTaskConfig{
+		Tasks: []map[string]interface{}{
+			{"name": "hello", "type": "echo", "echostr": "hello world"},
+			{"name": "fail", "type": "sh", "shellcmd": "echo oops; exit 1", "retries": 1},
+			{"name": "after", "type": "echo", "echostr": "x", "dependOn": []interface{}{"fail"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Run()
+	r := d.Result()
+	if r.Succeeded || len(r.Tasks) != 3 {
+		t.Fatalf("unexpected result %v", r)
+	}
+	results := map[string]TaskResult{}
+	for _, tr := range r.Tasks {
+		results[tr.Name] = tr
+	}
+	if tr := results["hello"]; tr.State != StateSucceeded || tr.Output != "hello world\n" || tr.Start.IsZero() {
+		t.Fatalf("unexpected result of hello %v", tr)
+	}
+	if tr := results["fail"]; tr.State != StateFailed || tr.Attempts != 2 || tr.Output != "oops\noops\n" || tr.Error != "exit status 1" {
+		t.Fatalf("unexpected result of fail %v", tr)
+	}
+	if tr := results["after"]; tr.State != StateUpstreamFailed || tr.Attempts != 0 {
+		t.Fatalf("unexpected result of after %v", tr)
+	}
+
+	var buf bytes.Buffer
+	if err := r.WriteTable(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "upstream_failed") {
+		t.Fatalf("unexpected table %s", buf.String())
+	}
+	buf.Reset()
+	if err := r.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var decoded RunResult
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded.Tasks) != 3 || decoded.Tasks[0].Output != r.Tasks[0].Output {
+		t.Fatalf("unexpected json %s", buf.String())
+	}
+
+	// tasks not selected by the last run are not reported
+	if err := d.RunTask("hello"); err != nil {
+		t.Fatal(err)
+	}
+	if r := d.Result(); !r.Succeeded || len(r.Tasks) != 1 || r.Tasks[0].Name != "hello" {
+		t.Fatalf("unexpected result of RunTask %+v", r)
+	}
+}
+
+func TestTailBuffer(t *testing.T) {
+	b := newTailBuffer(5)
+	b.Write([]byte("abc"))
+	b.Write([]byte("defg"))
+	if b.String() != "cdefg" {
+		t.Fatalf("unexpected tail %q", b.String())
+	}
+	b.Write([]byte("0123456789"))
+	if b.String() != "56789" {
+		t.Fatalf("unexpected tail %q", b.String())
+	}
+}
+
+func TestRunResultSkipped(t *testing.T) {
+	d, err := CreateTaskDag(DagTaskConfig{
+		Tasks: []map[string]interface{}{
+			{"name": "hello", "type": "echo", "echostr": "hello world"},
+			{"name": "never", "type": "echo", "echostr": "x", "when": "false"},
+			{"name": "after", "type": "echo", "echostr": "x", "dependOn": []interface{}{"never"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Run(); err != nil {
+		t.Fatal(err)
+	}
+	r := d.Result()
+	if !r.Succeeded {
+		t.Fatalf("skipped tasks should not fail the run %+v", r)
+	}
+	if states := r.States(); states["never"] != StateSkipped || states["after"] != StateSkipped {
+		t.Fatalf("unexpected states %v", states)
+	}
+}
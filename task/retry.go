@@ -0,0 +1,129 @@
+package task
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"os/exec"
+	"regexp"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RetryPolicy decides whether and when a failed task runs again. It is read
+// from these task config fields
+//
+//	retries            max retry times, 0 means no retry
+//	retryDelay         delay before the first retry, like "10s"
+//	retryBackoff       multiply the delay by it for each retry, 2 for exponential
+//	retryMaxDelay      upper limit of the delay
+//	retryJitter        randomize the delay by +-jitter, 0.2 means +-20%
+//	retryOnExitCodes   only retry if the command exits with one of the codes
+//	retryOnErrors      only retry if the error matches one of the regexps
+//
+// Without retryOnExitCodes and retryOnErrors any error is retryable.
+type RetryPolicy struct {
+	Retries   int
+	Delay     time.Duration
+	Backoff   float64
+	MaxDelay  time.Duration
+	Jitter    float64
+	ExitCodes []int
+	Matchers  []*regexp.Regexp
+}
+
+func newRetryPolicy(conf map[string]interface{}) (*RetryPolicy, error) {
+	v, ok := conf["retries"]
+	if !ok {
+		return nil, nil
+	}
+	p := &RetryPolicy{Backoff: 1}
+	var err error
+	if p.Retries, ok = toInt(v); !ok || p.Retries < 0 {
+		return nil, fmt.Errorf("retries should be a positive integer, got %v", v)
+	}
+	if v, ok := conf["retryDelay"]; ok {
+		if p.Delay, err = toDuration(v); err != nil {
+			return nil, errors.Wrap(err, "bad retryDelay")
+		}
+	}
+	if v, ok := conf["retryMaxDelay"]; ok {
+		if p.MaxDelay, err = toDuration(v); err != nil {
+			return nil, errors.Wrap(err, "bad retryMaxDelay")
+		}
+	}
+	if v, ok := conf["retryBackoff"]; ok {
+		if p.Backoff, ok = toFloat(v); !ok || p.Backoff < 1 {
+			return nil, fmt.Errorf("retryBackoff should be a number >= 1, got %v", v)
+		}
+	}
+	if v, ok := conf["retryJitter"]; ok {
+		if p.Jitter, ok = toFloat(v); !ok || p.Jitter < 0 || p.Jitter > 1 {
+			return nil, fmt.Errorf("retryJitter should be between 0 and 1, got %v", v)
+		}
+	}
+	if v, ok := conf["retryOnExitCodes"]; ok {
+		codes, ok := v.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("retryOnExitCodes should be a list, got %v", v)
+		}
+		for _, c := range codes {
+			code, ok := toInt(c)
+			if !ok {
+				return nil, fmt.Errorf("bad exit code %v", c)
+			}
+			p.ExitCodes = append(p.ExitCodes, code)
+		}
+	}
+	if v, ok := conf["retryOnErrors"]; ok {
+		patterns, err := toStringList(v)
+		if err != nil {
+			return nil, errors.Wrap(err, "bad retryOnErrors")
+		}
+		for _, pat := range patterns {
+			re, err := regexp.Compile(pat.(string))
+			if err != nil {
+				return nil, errors.Wrap(err, "bad retryOnErrors")
+			}
+			p.Matchers = append(p.Matchers, re)
+		}
+	}
+	return p, nil
+}
+
+// ShouldRetry tells whether to retry after attempt (starts from 1) failed
+func (p *RetryPolicy) ShouldRetry(attempt int, err error) bool {
+	if p == nil || err == nil || attempt > p.Retries {
+		return false
+	}
+	if len(p.ExitCodes) == 0 && len(p.Matchers) == 0 {
+		return true
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		for _, code := range p.ExitCodes {
+			if exitErr.ExitCode() == code {
+				return true
+			}
+		}
+	}
+	for _, re := range p.Matchers {
+		if re.MatchString(err.Error()) {
+			return true
+		}
+	}
+	return false
+}
+
+// BackoffDelay returns the delay before the retry after attempt
+func (p *RetryPolicy) BackoffDelay(attempt int) time.Duration {
+	d := float64(p.Delay) * math.Pow(p.Backoff, float64(attempt-1))
+	if p.MaxDelay > 0 && d > float64(p.MaxDelay) {
+		d = float64(p.MaxDelay)
+	}
+	if p.Jitter > 0 {
+		d += d * p.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(d)
+}
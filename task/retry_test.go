@@ -0,0 +1,71 @@
+package task
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// fails until it's run the third time
+const flakyCmd = `n=$(cat counter 2>/dev/null || echo 0); n=$((n+1)); echo $n > counter; [ $n -ge 3 ] || exit 7`
+
+func TestRetry(t *testing.T) {
+	cases := []struct {
+		conf     map[string]interface{}
+		attempts int
+		failed   bool
+	}{
+		{map[string]interface{}{}, 1, true},
+		{map[string]interface{}{"retries": 1}, 2, true},
+		{map[string]interface{}{"retries": 5, "retryDelay": "1ms", "retryBackoff": 2}, 3, false},
+		{map[string]interface{}{"retries": 5, "retryOnExitCodes": []interface{}{7}}, 3, false},
+		{map[string]interface{}{"retries": 5, "retryOnExitCodes": []interface{}{1}}, 1, true},
+		{map[string]interface{}{"retries": 5, "retryOnErrors": "exit status [0-9]"}, 3, false},
+	}
+	for _, c := range cases {
+		dir := t.TempDir()
+		c.conf["name"] = "flaky"
+		c.conf["type"] = "sh"
+		c.conf["shellcmd"] = flakyCmd
+		c.conf["shellcwd"] = dir
+		d, err := CreateTaskDag(DagTaskConfig{Tasks: []map[string]interface{}{c.conf}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = d.Run()
+		if (err != nil) != c.failed {
+			t.Fatalf("%v: unexpected error %v", c.conf, err)
+		}
+		data, _ := ioutil.ReadFile(filepath.Join(dir, "counter"))
+		if attempts := d.Nodes()[0].(*task).attempts; attempts != c.attempts || string(data) != strconv.Itoa(attempts)+"\n" {
+			t.Fatalf("%v: expect %d attempts, got %d", c.conf, c.attempts, attempts)
+		}
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	p, err := newRetryPolicy(map[string]interface{}{
+		"retries": 10, "retryDelay": 1.0, "retryBackoff": 2, "retryMaxDelay": "5s",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	delays := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second}
+	for i, d := range delays {
+		if p.BackoffDelay(i+1) != d {
+			t.Fatalf("attempt %d: expect delay %v, got %v", i+1, d, p.BackoffDelay(i+1))
+		}
+	}
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if d := p.BackoffDelay(1); d < 500*time.Millisecond || d > 1500*time.Millisecond {
+			t.Fatalf("delay %v out of jitter range", d)
+		}
+	}
+	if p.ShouldRetry(11, errors.New("failed")) {
+		t.Fatal("should not retry after retries used up")
+	}
+}
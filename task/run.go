@@ -3,46 +3,294 @@ package task
 import (
 	"context"
 	"fmt"
+	"io"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/zxdvd/go-libs/dag"
-	"github.com/zxdvd/go-libs/future"
-	"go.uber.org/zap"
 )
 
-var logger *zap.Logger
-
-func init() {
-	defaultLogger, _ := zap.NewDevelopment()
-	logger = defaultLogger
-}
-
 type TaskHook func(*task) error
 
 type task struct {
 	Task
+	typ string
+	// config the task is created from, it's used to create the task again
+	// when it references variables
+	config    map[string]interface{}
+	templated bool
+	vars      *runVars
+	trigger   TriggerRule
+	// run only if it's true, nil means always
+	when         expr
 	preRunHooks  []TaskHook
 	postRunHooks []TaskHook
 	dependOn     []*task
-	m            sync.Mutex
-	done         bool
-	pool         *RunnerPool
+	priority     int
+	index        int
+	pools        []poolUse
+	dagPools     map[string]*RunnerPool
+	logger       Logger
+	sink         OutputSink
+	retry        *RetryPolicy
+	attempts     int
+	timeout      time.Duration
+	timedOut     bool
+	state        TaskState
+	err          error
+	start        time.Time
+	end          time.Time
+	// tail of the output
+	output *tailBuffer
+	// succeeded in the resumed run and not run again
+	restored   bool
+	outputs    map[string]string
+	outputLock sync.Mutex
+	// tasks of the longest chain starting from it, set for each run
+	pathLen int
+	// timeout or retry fields reference variables, they are parsed by
+	// instantiate
+	runnerTemplated bool
 }
 
+// NewTask creates a task of typ from its config, errors of all invalid fields
+// are returned as a *ConfigError
 func NewTask(typ string, t map[string]interface{}) (*task, error) {
-	t1, err := newTask(typ, t)
-	if err != nil {
+	name, _ := t["name"].(string)
+	errs := &ConfigError{Task: name}
+	t1, err := createTask(typ, t)
+	errs.add("", err)
+	// they are parsed for each run if they reference variables
+	runnerTemplated := false
+	for _, k := range runnerFields {
+		if valueHasTemplateVars(t[k]) {
+			runnerTemplated = true
+		}
+	}
+	var retry *RetryPolicy
+	var timeout time.Duration
+	if !runnerTemplated {
+		retry, timeout = parseRunnerFields(t, errs)
+	}
+	pools, err := newPoolUses(t)
+	errs.add("pools", err)
+	var priority int
+	if v, ok := t["priority"]; ok {
+		if priority, ok = toInt(v); !ok {
+			errs.add("priority", fmt.Errorf("expect an integer, got %v", v))
+		}
+	}
+	// retries, timeout and pools of map tasks apply to each instance
+	if _, ok := t1.(*MapTask); ok {
+		retry, timeout, runnerTemplated = nil, 0, false
+		pools = []poolUse{{name: DefaultPool, weight: 1}}
+	}
+	trigger := AllSuccess
+	if v, ok := t["triggerRule"]; ok {
+		rule, _ := v.(string)
+		trigger = TriggerRule(rule)
+		errs.add("triggerRule", trigger.validate())
+	}
+	var when expr
+	if v, ok := t["when"]; ok {
+		errs.add("when", func() error {
+			s, ok := v.(string)
+			if !ok {
+				return fmt.Errorf("expect a string, got %v", v)
+			}
+			if when, err = parseExpr(s); err != nil {
+				return err
+			}
+			for _, name := range exprVars(when) {
+				if !isTemplateVar(name) {
+					return fmt.Errorf("unknown variable %s", name)
+				}
+			}
+			return nil
+		}())
+	}
+	if err := errs.err(); err != nil {
 		return nil, err
 	}
 	if t1 == nil {
 		return nil, nil
 	}
+	// escapes are replaced like variables
+	templated := configHasEscapes(t)
+	for _, name := range configVars(t) {
+		if isTemplateVar(name) {
+			templated = true
+		}
+	}
 	return &task{
-		Task: t1,
+		Task:            t1,
+		typ:             typ,
+		config:          t,
+		templated:       templated,
+		runnerTemplated: runnerTemplated,
+		retry:           retry,
+		timeout:         timeout,
+		pools:           pools,
+		priority:        priority,
+		trigger:         trigger,
+		when:            when,
+		state:           StatePending,
+		output:          newTailBuffer(defaultTailSize),
+		outputs:         map[string]string{},
 	}, nil
 }
 
+// runnerFields are fields of retries and timeouts, which may reference
+// variables. Other fields of the runner, like pools, priority, triggerRule
+// and when, are never templated since they are needed before runs.
+var runnerFields = []string{"timeout", "retries", "retryDelay", "retryBackoff", "retryMaxDelay", "retryJitter"}
+
+// parseRunnerFields parses the retry policy and timeout of a task config
+func parseRunnerFields(conf map[string]interface{}, errs *ConfigError) (*RetryPolicy, time.Duration) {
+	retry, err := newRetryPolicy(conf)
+	errs.add("", err)
+	var timeout time.Duration
+	if v, ok := conf["timeout"]; ok {
+		timeout, err = toDuration(v)
+		errs.add("timeout", err)
+	}
+	return retry, timeout
+}
+
+// upstreams returns all tasks that t depends on directly or indirectly
+func (t *task) upstreams() map[string]*task {
+	ups := map[string]*task{}
+	var walk func(*task)
+	walk = func(t *task) {
+		for _, dep := range t.dependOn {
+			if _, ok := ups[dep.Name()]; !ok {
+				ups[dep.Name()] = dep
+				walk(dep)
+			}
+		}
+	}
+	walk(t)
+	return ups
+}
+
+// checkOutputVars makes sure that referenced outputs are from upstream tasks
+func (t *task) checkOutputVars() error {
+	ups := t.upstreams()
+	names := configVars(t.config)
+	if t.when != nil {
+		names = append(names, exprVars(t.when)...)
+	}
+	for _, name := range names {
+		taskName, _, ok := parseOutputVar(name)
+		if !ok {
+			continue
+		}
+		if _, ok := ups[taskName]; !ok {
+			return fmt.Errorf("{%s} references a task not depended on", name)
+		}
+	}
+	return nil
+}
+
+// expandConfig returns config with variables replaced, references to outputs
+// of upstream tasks are kept as is if withOutputs is false
+func (t *task) expandConfig(withOutputs bool) (map[string]interface{}, error) {
+	return t.configExpander(t.vars, withOutputs).config(t.config)
+}
+
+// configExpander expands the config by configLookup, escapes are kept for map
+// tasks since each instance expands its config again
+func (t *task) configExpander(vars *runVars, withOutputs bool) expander {
+	_, isMap := t.config["mapOver"]
+	return expander{lookup: t.configLookup(vars, withOutputs), keepEscapes: isMap}
+}
+
+// configLookup is like lookupVars, but {name} is kept for map tasks so that
+// each instance replaces it by its own name
+func (t *task) configLookup(vars *runVars, withOutputs bool) lookupFunc {
+	lookup := t.lookupVars(vars, withOutputs)
+	if _, ok := t.config["mapOver"]; !ok {
+		return lookup
+	}
+	return func(name, format string) (string, bool, error) {
+		if name == "name" {
+			return "", false, nil
+		}
+		return lookup(name, format)
+	}
+}
+
+func (t *task) lookup(withOutputs bool) lookupFunc {
+	return t.lookupVars(t.vars, withOutputs)
+}
+
+// lookupVars is like lookup but resolves variables of the run by vars
+func (t *task) lookupVars(vars *runVars, withOutputs bool) lookupFunc {
+	ups := t.upstreams()
+	return func(name, format string) (string, bool, error) {
+		taskName, key, ok := parseOutputVar(name)
+		if !ok {
+			return vars.lookup(t.Name(), name, format)
+		}
+		if !withOutputs {
+			return "", false, nil
+		}
+		value, ok := ups[taskName].getOutput(key)
+		if !ok {
+			return "", false, fmt.Errorf("output %s of task %s not found", key, taskName)
+		}
+		return value, true, nil
+	}
+}
+
+// instantiate creates the task again from config with variables replaced
+func (t *task) instantiate() error {
+	conf, err := t.expandConfig(true)
+	if err != nil {
+		return err
+	}
+	impl, err := createTask(t.typ, conf)
+	if err != nil {
+		return err
+	}
+	if t.runnerTemplated {
+		errs := &ConfigError{Task: t.Name()}
+		retry, timeout := parseRunnerFields(conf, errs)
+		if err := errs.err(); err != nil {
+			return err
+		}
+		t.retry, t.timeout = retry, timeout
+	}
+	t.Task = impl
+	return nil
+}
+
+func (t *task) setOutput(key, value string) {
+	t.outputLock.Lock()
+	defer t.outputLock.Unlock()
+	t.outputs[key] = value
+}
+
+func (t *task) getOutput(key string) (string, bool) {
+	t.outputLock.Lock()
+	defer t.outputLock.Unlock()
+	value, ok := t.outputs[key]
+	return value, ok
+}
+
+// copyOutputs returns a copy of all outputs
+func (t *task) copyOutputs() map[string]string {
+	t.outputLock.Lock()
+	defer t.outputLock.Unlock()
+	outputs := make(map[string]string, len(t.outputs))
+	for k, v := range t.outputs {
+		outputs[k] = v
+	}
+	return outputs
+}
+
 func (t *task) Nexts() []dag.Node {
 	nodes := make([]dag.Node, len(t.dependOn))
 	for i, dep := range t.dependOn {
@@ -51,58 +299,104 @@ func (t *task) Nexts() []dag.Node {
 	return nodes
 }
 
-func (t *task) run(ctx context.Context) error {
-	if t.done {
-		return nil
-	}
-	if t.pool != nil {
-		t.pool.Get()
-		defer t.pool.Put()
-	}
-	t.m.Lock()
-	defer t.m.Unlock()
-	if t.done {
-		return nil
+// reset clears the state of last run
+func (t *task) reset(vars *runVars) {
+	t.vars = vars
+	t.state = StatePending
+	t.err = nil
+	t.attempts = 0
+	t.timedOut = false
+	t.start = time.Time{}
+	t.end = time.Time{}
+	t.output = newTailBuffer(defaultTailSize)
+	t.restored = false
+	t.outputs = map[string]string{}
+}
+
+// restore marks the task succeeded without running it
+func (t *task) restore(outputs map[string]string) {
+	t.state = StateSucceeded
+	t.restored = true
+	for k, v := range outputs {
+		t.outputs[k] = v
 	}
-	logger.Debug("run task", zap.String("name", t.Name()))
-	err := t.Task.Run(ctx)
-	logger.Debug("run task finished", zap.Error(err), zap.String("name", t.Name()))
-	t.done = true
-	return err
 }
 
-func (t *task) Run(ctx context.Context) error {
-	futures := future.NewN(len(t.dependOn))
-	for i, dep := range t.dependOn {
-		go func(i int, t *task) {
-			err := t.Run(ctx)
-			if err != nil {
-				futures[i].SetError(err)
-			} else {
-				futures[i].SetResult(true)
-			}
-		}(i, dep)
+// prepare checks whether t should run once its depended tasks finished, and
+// creates it again if it's templated
+func (t *task) prepare(ctx context.Context) error {
+	if ok, state := t.trigger.check(t.dependOn); !ok {
+		t.state = state
+		if state == StateUpstreamFailed {
+			return errors.Wrapf(ErrUpstreamFailed, "trigger rule %s", t.trigger)
+		}
+		return errors.Wrapf(ErrSkipped, "trigger rule %s", t.trigger)
 	}
-	// wait and resolve all depends tasks
-	if _, err := future.GetAll(futures); err != nil {
-		return err
+	if t.context(ctx).Err() != nil {
+		t.state = StateSkipped
+		return errors.Wrap(ErrSkipped, "run canceled")
 	}
-	for _, fn := range t.preRunHooks {
-		if err := fn(t); err != nil {
-			return errors.Wrap(err, "preRunHooks fails")
+	if t.when != nil {
+		ok, err := evalBool(t.when, t.lookup(true))
+		if err != nil {
+			t.state = StateFailed
+			return errors.Wrap(err, "when")
+		}
+		if !ok {
+			t.state = StateSkipped
+			return errors.Wrap(ErrSkipped, "when is false")
 		}
 	}
-	if err := t.run(ctx); err != nil {
-		return err
+	if t.templated {
+		if err := t.instantiate(); err != nil {
+			t.state = StateFailed
+			return err
+		}
 	}
-	for _, fn := range t.postRunHooks {
+	for _, fn := range t.preRunHooks {
 		if err := fn(t); err != nil {
-			return errors.Wrap(err, "postRunHooks fails")
+			t.state = StateFailed
+			return errors.Wrap(err, "preRunHooks fails")
 		}
 	}
 	return nil
 }
 
+// runOnce runs an attempt of the task holding slot
+func (t *task) runOnce(ctx context.Context, slot *poolSlot) error {
+	ctx = withPoolSlot(ctx, slot)
+	out := Output(ctx)
+	if t.sink != nil {
+		w, err := t.sink.Open(t.Name(), t.attempts)
+		if err != nil {
+			return errors.Wrap(err, "open output sink")
+		}
+		defer w.Close()
+		out = w
+	}
+	ctx = withOutput(ctx, io.MultiWriter(out, t.output))
+	ctx = withOutputs(ctx, t)
+	ctx = withLogger(ctx, t.logger.With(F("name", t.Name())))
+	ctx = withRunVars(ctx, t.vars)
+	if t.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.timeout)
+		defer cancel()
+	}
+	t.logger.Debug("run task", F("name", t.Name()), F("attempt", t.attempts))
+	emit(ctx, Event{Type: EventTaskStarted, Task: t.Name(), Attempt: t.attempts})
+	err := t.Task.Run(ctx)
+	if ctx.Err() == context.DeadlineExceeded {
+		t.timedOut = true
+		err = errors.WithStack(ErrTimeout)
+	} else if ctx.Err() != nil {
+		err = errors.WithStack(ctx.Err())
+	}
+	t.logger.Debug("run task finished", F("error", err), F("name", t.Name()),
+		F("attempt", t.attempts))
+	return err
+}
+
 var _ dag.Node = &task{}
 
 var defaultConcurrentLimit = 3
@@ -110,25 +404,136 @@ var defaultConcurrentLimit = 3
 type DagTaskConfig struct {
 	Tasks           []map[string]interface{}
 	ConcurrentLimit int
+	// stop all tasks if the whole run exceeds it, 0 means no timeout
+	Timeout time.Duration
+	// FailFast by default
+	Mode RunMode
+	// default parameters of runs, {params.KEY} in task configs
+	Params map[string]string
+	// used by Scheduler, nil if the dag isn't scheduled
+	Schedule *Schedule
+	// sizes of named pools besides the default pool of ConcurrentLimit slots
+	Pools map[string]int
+	// databases of sql tasks using them by names, tasks of map tasks and
+	// inline sub dags use connections of the parent dag too
+	Connections map[string]*Connection
+	// pools not in Pools are those of the parent dag, for dags of map tasks
+	// and inline tasks of sub dags
+	inheritPools bool
+}
+
+// TaskConfigError reports which entry of DagTaskConfig.Tasks is invalid
+type TaskConfigError struct {
+	Index int
+	Task  string
+	Err   error
+}
+
+func (e *TaskConfigError) Error() string {
+	if e.Task == "" {
+		return fmt.Sprintf("task #%d: %v", e.Index, e.Err)
+	}
+	return fmt.Sprintf("task %s: %v", e.Task, e.Err)
+}
+
+func (e *TaskConfigError) Cause() error {
+	return e.Err
+}
+
+// TaskConfigErrors holds errors of all invalid tasks
+type TaskConfigErrors []*TaskConfigError
+
+func (e TaskConfigErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("%d invalid tasks: %s", len(e), strings.Join(msgs, "; "))
 }
 
+func (e TaskConfigErrors) err() error {
+	switch len(e) {
+	case 0:
+		return nil
+	case 1:
+		return e[0]
+	}
+	return e
+}
+
+// CreateTaskDag creates the dag of tasks of c. Invalid tasks are reported
+// together, as a *TaskConfigError if there is only one, or TaskConfigErrors.
 func CreateTaskDag(c DagTaskConfig) (*dagTask, error) {
 	if c.ConcurrentLimit == 0 {
 		c.ConcurrentLimit = defaultConcurrentLimit
 	}
+	if c.Mode == "" {
+		c.Mode = FailFast
+	}
+	if err := c.Mode.validate(); err != nil {
+		return nil, err
+	}
+	pools := map[string]*RunnerPool{DefaultPool: NewRunnerPool(c.ConcurrentLimit)}
+	for name, size := range c.Pools {
+		if name == DefaultPool {
+			return nil, fmt.Errorf("pool %s is defined by the concurrent limit", DefaultPool)
+		}
+		if size < 1 {
+			return nil, fmt.Errorf("size of pool %s should be a positive integer, got %d", name, size)
+		}
+		pools[name] = NewRunnerPool(size)
+	}
+	conns := map[string]*Connection{}
+	for name, conn := range c.Connections {
+		if conn == nil || conn.Dialect == "" || conn.Uri == "" {
+			return nil, fmt.Errorf("dialect and uri of connection %s are needed", name)
+		}
+		conns[name] = conn
+	}
+	var errs TaskConfigErrors
+	invalid := func(i int, name string, err error) {
+		errs = append(errs, &TaskConfigError{Index: i, Task: name, Err: err})
+	}
 	taskmap := map[string]*task{}
-	for _, tc := range c.Tasks {
-		t, err := NewTask(tc["type"].(string), tc)
+	tasks := make([]*task, 0, len(c.Tasks))
+	for i, tc := range c.Tasks {
+		name, _ := tc["name"].(string)
+		typ, ok := tc["type"].(string)
+		if !ok {
+			invalid(i, name, errors.New("task type missing"))
+			continue
+		}
+		t, err := NewTask(typ, tc)
 		if err != nil {
-			return nil, err
+			invalid(i, name, err)
+			continue
 		}
 		if t == nil {
 			continue
 		}
+		if _, ok := taskmap[t.Name()]; ok {
+			invalid(i, name, errors.New("duplicated task name"))
+			continue
+		}
+		if err := checkPools(t.pools, pools, c.inheritPools); err != nil {
+			invalid(i, name, err)
+			continue
+		}
+		if u, ok := t.Task.(interface{ connection() string }); ok && !t.templated && !c.inheritPools {
+			if conn := u.connection(); conn != "" && conns[conn] == nil {
+				invalid(i, name, fmt.Errorf("unknown connection %s", conn))
+				continue
+			}
+		}
+		t.index = len(tasks)
 		taskmap[t.Name()] = t
+		tasks = append(tasks, t)
+	}
+	if err := errs.err(); err != nil {
+		return nil, err
 	}
 	// deal with task depends
-	for _, tc := range c.Tasks {
+	for i, tc := range c.Tasks {
 		taskname, ok := tc["name"].(string)
 		if !ok {
 			continue
@@ -137,7 +542,11 @@ func CreateTaskDag(c DagTaskConfig) (*dagTask, error) {
 		if tc["dependOn"] == nil {
 			continue
 		}
-		dependOn := tc["dependOn"].([]interface{})
+		dependOn, ok := tc["dependOn"].([]interface{})
+		if !ok {
+			invalid(i, taskname, errors.New("dependOn should be a list"))
+			continue
+		}
 		depends := make([]*task, 0, len(dependOn))
 		for _, depend := range dependOn {
 			dep, ok := depend.(string)
@@ -147,89 +556,304 @@ func CreateTaskDag(c DagTaskConfig) (*dagTask, error) {
 			if deptask, ok := taskmap[dep]; ok {
 				depends = append(depends, deptask)
 			} else {
-				return nil, fmt.Errorf("depended task %s not found", dep)
+				invalid(i, taskname, fmt.Errorf("depended task %s not found", dep))
 			}
 		}
 		t.dependOn = depends
 	}
+	if err := errs.err(); err != nil {
+		return nil, err
+	}
+	for i, tc := range c.Tasks {
+		name, _ := tc["name"].(string)
+		t, ok := taskmap[name]
+		if !ok {
+			continue
+		}
+		if err := t.checkOutputVars(); err != nil {
+			invalid(i, name, err)
+		}
+	}
+	if err := errs.err(); err != nil {
+		return nil, err
+	}
 	dag_ := &dag.Dag{}
-	for _, t := range taskmap {
+	for _, t := range tasks {
 		dag_.Add(t)
 	}
 	if err := dag_.CircleDetect(); err != nil {
 		return nil, err
 	}
+	for _, t := range tasks {
+		bindPools(t.pools, pools)
+		t.dagPools = pools
+		t.logger = defaultLogger
+	}
+	params := map[string]string{}
+	for k, v := range c.Params {
+		params[k] = v
+	}
 	return &dagTask{
-		Dag:  dag_,
-		pool: NewRunnerPool(defaultConcurrentLimit),
+		Dag:          dag_,
+		pools:        pools,
+		conns:        conns,
+		inheritPools: c.inheritPools,
+		logger:       defaultLogger,
+		timeout:      c.Timeout,
+		mode:         c.Mode,
+		params:       params,
 	}, nil
 }
 
 type dagTask struct {
 	*dag.Dag
-	pool *RunnerPool
+	pools   map[string]*RunnerPool
+	conns   map[string]*Connection
+	timeout time.Duration
+	mode    RunMode
+	// start and end time of the last run
+	start time.Time
+	end   time.Time
+	// tasks selected by the last run, nil before the first run
+	selected func(*task) bool
+	// save task states to store if it's set
+	store    StateStore
+	runID    string
+	runState *RunState
+	lock     sync.Mutex
+	params   map[string]string
+	runDate  time.Time
+	// variables of the last run
+	vars      *runVars
+	logger    Logger
+	observers []Observer
+	// os.Stdout or the output of the parent task if it's nil
+	sink OutputSink
+	// see DagTaskConfig.inheritPools
+	inheritPools bool
 }
 
-type RunnerPool struct {
-	limit int
-	pool  chan struct{}
+// RunMode decides what happens to other tasks when a task fails
+type RunMode string
+
+const (
+	// cancel running tasks, skip tasks not started and return the first error
+	FailFast RunMode = "fail-fast"
+	// run all tasks whose depended tasks succeeded and return all errors
+	ContinueOnError RunMode = "continue"
+)
+
+func (m RunMode) validate() error {
+	if m != FailFast && m != ContinueOnError {
+		return fmt.Errorf("unknown run mode %q", m)
+	}
+	return nil
 }
 
-func NewRunnerPool(n int) *RunnerPool {
-	return &RunnerPool{
-		limit: n,
-		pool:  make(chan struct{}, n),
+// MultiError holds errors of all failed tasks
+type MultiError []error
+
+func (e MultiError) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
 	}
+	return fmt.Sprintf("%d tasks failed: %s", len(e), strings.Join(msgs, "; "))
 }
 
-func (p *RunnerPool) Get() {
-	p.pool <- struct{}{}
+func (d *dagTask) SetMode(mode RunMode) error {
+	if err := mode.validate(); err != nil {
+		return err
+	}
+	d.mode = mode
+	return nil
 }
 
-func (p *RunnerPool) Put() {
-	<-p.pool
+// TaskInfo describes a task of the dag
+type TaskInfo struct {
+	Name     string
+	Type     string
+	DependOn []string
 }
 
-func (d *dagTask) RunTask(name string) error {
-	ctx, _ := context.WithCancel(context.Background())
-	nodes := d.Nodes()
-	for _, node := range nodes {
-		t, ok := node.(*task)
-		if !ok {
-			panic("task not implement node")
+// TaskInfos returns all tasks, a task is always after the tasks it depends on
+func (d *dagTask) TaskInfos() []TaskInfo {
+	infos := make([]TaskInfo, 0, len(d.Nodes()))
+	d.Iterate(func(node dag.Node) (bool, error) {
+		t := node.(*task)
+		info := TaskInfo{
+			Name:     t.Name(),
+			Type:     t.typ,
+			DependOn: make([]string, len(t.dependOn)),
 		}
-		if t.Name() != name {
-			continue
+		for i, dep := range t.dependOn {
+			info.DependOn[i] = dep.Name()
 		}
-		return t.Run(ctx)
+		infos = append(infos, info)
+		return true, nil
+	})
+	return infos
+}
+
+// RunTask runs the named task together with all tasks it depends on
+func (d *dagTask) RunTask(name string) error {
+	return d.RunTaskContext(context.Background(), name)
+}
+
+// RunTaskContext is like RunTask, running tasks are cancelled when ctx is done
+func (d *dagTask) RunTaskContext(ctx context.Context, name string) error {
+	selected, err := d.selectTask(name)
+	if err != nil {
+		return err
 	}
-	return nil
+	return d.run(ctx, selected, false)
+}
+
+// selectTask selects the named task and all tasks it depends on
+func (d *dagTask) selectTask(name string) (func(*task) bool, error) {
+	var target *task
+	for _, node := range d.Nodes() {
+		if t := node.(*task); t.Name() == name {
+			target = t
+		}
+	}
+	if target == nil {
+		return nil, fmt.Errorf("task %s not found", name)
+	}
+	needed := map[*task]bool{target: true}
+	for _, t := range target.upstreams() {
+		needed[t] = true
+	}
+	return func(t *task) bool {
+		return needed[t]
+	}, nil
+}
+
+func selectAll(*task) bool {
+	return true
+}
+
+func (d *dagTask) newRunVars() *runVars {
+	vars := &runVars{
+		runID:  d.runID,
+		date:   d.runDate,
+		params: make(map[string]string, len(d.params)),
+	}
+	now := time.Now()
+	if vars.runID == "" {
+		vars.runID = newRunID(now)
+	}
+	if vars.date.IsZero() {
+		vars.date = now
+	}
+	for k, v := range d.params {
+		vars.params[k] = v
+	}
+	return vars
+}
+
+func (d *dagTask) context(parent context.Context) (context.Context, context.CancelFunc) {
+	if d.timeout > 0 {
+		return context.WithTimeout(parent, d.timeout)
+	}
+	return context.WithCancel(parent)
 }
 
 func (d *dagTask) Run() error {
-	defer logger.Sync()
-	ctx, cancel := context.WithCancel(context.Background())
-	nodes := d.Nodes()
-	futures := future.NewN(len(nodes))
-	for i, node := range nodes {
+	return d.RunContext(context.Background())
+}
+
+// RunContext is like Run, running tasks are cancelled when ctx is done
+func (d *dagTask) RunContext(ctx context.Context) error {
+	return d.run(ctx, selectAll, false)
+}
+
+// Resume runs the dag again with the run id set by SetRunID, tasks succeeded
+// in the saved run are skipped
+func (d *dagTask) Resume() error {
+	return d.ResumeContext(context.Background())
+}
+
+// ResumeContext is like Resume, running tasks are cancelled when ctx is done
+func (d *dagTask) ResumeContext(ctx context.Context) error {
+	if d.store == nil || d.runID == "" {
+		return errors.New("no state store or run id to resume from")
+	}
+	return d.run(ctx, selectAll, true)
+}
+
+// run runs the selected tasks and waits until they all finish
+func (d *dagTask) run(parent context.Context, selected func(*task) bool, resume bool) (err error) {
+	if s, ok := d.logger.(interface{ Sync() error }); ok {
+		defer s.Sync()
+	}
+	base, cancelBase := d.context(parent)
+	defer cancelBase()
+	// fail-fast cancels ctx, tasks running after failures still use base
+	ctx, cancel := context.WithCancel(withBaseContext(base, base))
+	defer cancel()
+	d.start = time.Now()
+	defer func() {
+		d.end = time.Now()
+	}()
+	var tasks []*task
+	d.Iterate(func(node dag.Node) (bool, error) {
 		t, ok := node.(*task)
 		if !ok {
 			panic("task not implement node")
 		}
-		t.pool = d.pool
-		go func(i int) {
-			if err := t.Run(ctx); err != nil {
-				futures[i].SetError(err)
-			} else {
-				futures[i].SetResult(true)
-			}
-		}(i)
+		if selected(t) {
+			tasks = append(tasks, t)
+		}
+		return true, nil
+	})
+	d.vars = d.newRunVars()
+	d.selected = selected
+	for _, t := range tasks {
+		t.reset(d.vars)
 	}
-	if _, err := future.GetAll(futures); err != nil {
-		cancel()
-		logger.Debug("error:",
-			zap.Error(err), zap.Stack("stack"))
+	d.lock.Lock()
+	em := newEmitter(d.vars.runID, d.observers)
+	d.lock.Unlock()
+	em.emit(Event{Type: EventDagStarted})
+	defer func() {
+		d.end = time.Now()
+		em.emit(Event{Type: EventDagFinished, Err: err, Result: d.Result()})
+		em.close(d.logger)
+	}()
+	// sub dags run inside the run of the top dag and share its databases
+	dbs, closeDBs := runDBCache(parent)
+	defer closeDBs()
+	// tasks running after failures use base, which needs values of the run too
+	withRun := func(ctx context.Context) context.Context {
+		return withConnections(withDBCache(withEmitter(ctx, em), dbs), d.conns)
+	}
+	ctx = withBaseContext(withRun(ctx), withRun(base))
+	// fail early if any variable can't be resolved
+	for _, t := range tasks {
+		if !t.templated {
+			continue
+		}
+		if _, err := t.expandConfig(false); err != nil {
+			return errors.Wrapf(err, "task %s", t.Name())
+		}
+	}
+	if err := d.loadRunState(tasks, resume); err != nil {
 		return err
 	}
+	s := newDispatcher(ctx, cancel, d, tasks)
+	s.run()
+	if d.mode == FailFast {
+		return s.firstErr
+	}
+	var errs MultiError
+	for _, t := range tasks {
+		if t.state == StateFailed {
+			errs = append(errs, errors.Wrapf(t.err, "task %s", t.Name()))
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
 	return nil
 }
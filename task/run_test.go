@@ -0,0 +1,94 @@
+package task
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func createTestDag(t *testing.T, mode RunMode) *dagTask {
+	d, err := CreateTaskDag(DagTaskConfig{
+		Mode: mode,
+		Tasks: []map[string]interface{}{
+			{"name": "fail", "type": "sh", "shellcmd": "sleep 0.1; exit 1"},
+			{"name": "fail2", "type": "sh", "shellcmd": "sleep 0.2; exit 2"},
+			{"name": "slow", "type": "sh", "shellcmd": "sleep 5"},
+			{"name": "ok", "type": "echo", "echostr": "ok"},
+			{"name": "after_fail", "type": "echo", "echostr": "x", "dependOn": []interface{}{"fail"}},
+			{"name": "after_slow", "type": "echo", "echostr": "x", "dependOn": []interface{}{"slow", "ok"}},
+		},
+		ConcurrentLimit: 10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func taskStates(d *dagTask) map[string]TaskState {
+	states := map[string]TaskState{}
+	for _, node := range d.Nodes() {
+		states[node.(*task).Name()] = node.(*task).state
+	}
+	return states
+}
+
+func TestFailFast(t *testing.T) {
+	d := createTestDag(t, FailFast)
+	start := time.Now()
+	err := d.Run()
+	if err == nil || err.Error() != "task fail: exit status 1" {
+		t.Fatalf("expect error of task fail, got %v", err)
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Fatal("running tasks not canceled")
+	}
+	expect := map[string]TaskState{
+		"fail":       StateFailed,
+		"fail2":      StateCancelled,
+		"slow":       StateCancelled,
+		"ok":         StateSucceeded,
+		"after_fail": StateUpstreamFailed,
+		"after_slow": StateSkipped,
+	}
+	if states := taskStates(d); !equalStates(states, expect) {
+		t.Fatalf("unexpected states %v", states)
+	}
+}
+
+func TestContinueOnError(t *testing.T) {
+	d := createTestDag(t, ContinueOnError)
+	d.Nodes()[2].(*task).timeout = 500 * time.Millisecond
+	err := d.Run()
+	errs, ok := err.(MultiError)
+	if !ok || len(errs) != 3 {
+		t.Fatalf("expect 3 errors, got %v", err)
+	}
+	if errors.Cause(errs[2]) != ErrTimeout {
+		t.Fatalf("expect timeout error of task slow, got %v", errs[2])
+	}
+	expect := map[string]TaskState{
+		"fail":       StateFailed,
+		"fail2":      StateFailed,
+		"slow":       StateFailed,
+		"ok":         StateSucceeded,
+		"after_fail": StateUpstreamFailed,
+		"after_slow": StateUpstreamFailed,
+	}
+	if states := taskStates(d); !equalStates(states, expect) {
+		t.Fatalf("unexpected states %v", states)
+	}
+}
+
+func equalStates(a, b map[string]TaskState) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if b[k] != v {
+			return false
+		}
+	}
+	return true
+}
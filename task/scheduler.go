@@ -0,0 +1,378 @@
+package task
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/robfig/cron/v3"
+)
+
+// OverlapPolicy decides what happens when a run is due while the last run of
+// the same workflow is still running
+type OverlapPolicy string
+
+const (
+	// drop the due run
+	OverlapSkip OverlapPolicy = "skip"
+	// run it after the running one finishes
+	OverlapQueue OverlapPolicy = "queue"
+)
+
+// Schedule tells when a workflow runs, in a workflow file it's like
+//
+//	schedule:
+//	  cron: "0 2 * * *"
+//	  timezone: Asia/Shanghai
+//	  overlap: queue
+//	  maxCatchUp: 3
+//
+// or just the cron expression like schedule: "@hourly".
+type Schedule struct {
+	// standard cron expression with 5 fields, or descriptors like @daily and
+	// @every 10m
+	Cron string
+	// name of the IANA time zone, local time if empty
+	Timezone string
+	// OverlapSkip by default
+	Overlap OverlapPolicy
+	// at most this many runs missed while the scheduler was down are run when
+	// it starts again, the latest ones are chosen, 0 means no catch-up
+	MaxCatchUp int
+}
+
+func (s Schedule) parse() (cron.Schedule, *time.Location, error) {
+	sched, err := cron.ParseStandard(s.Cron)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "bad cron")
+	}
+	loc := time.Local
+	if s.Timezone != "" {
+		if loc, err = time.LoadLocation(s.Timezone); err != nil {
+			return nil, nil, errors.Wrap(err, "bad timezone")
+		}
+	}
+	switch s.Overlap {
+	case "", OverlapSkip, OverlapQueue:
+	default:
+		return nil, nil, fmt.Errorf("unknown overlap policy %q", s.Overlap)
+	}
+	if s.MaxCatchUp < 0 {
+		return nil, nil, fmt.Errorf("maxCatchUp should not be negative, got %d", s.MaxCatchUp)
+	}
+	return sched, loc, nil
+}
+
+func newSchedule(v interface{}) (*Schedule, error) {
+	if expr, ok := v.(string); ok {
+		v = map[string]interface{}{"cron": expr}
+	}
+	raw, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("schedule should be a cron expression or a mapping, got %v", v)
+	}
+	s := &Schedule{}
+	for k, v := range raw {
+		var ok bool
+		switch k {
+		case "cron":
+			s.Cron, ok = v.(string)
+		case "timezone":
+			s.Timezone, ok = v.(string)
+		case "overlap":
+			var overlap string
+			overlap, ok = v.(string)
+			s.Overlap = OverlapPolicy(overlap)
+		case "maxCatchUp":
+			s.MaxCatchUp, ok = toInt(v)
+		default:
+			return nil, fmt.Errorf("unknown schedule field %s", k)
+		}
+		if !ok {
+			return nil, fmt.Errorf("bad schedule field %s: %v", k, v)
+		}
+	}
+	if _, _, err := s.parse(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// missedTimes returns at most max latest times of sched after last and not
+// after now
+func missedTimes(sched cron.Schedule, loc *time.Location, last, now time.Time, max int) []time.Time {
+	var times []time.Time
+	if max <= 0 || last.IsZero() {
+		return nil
+	}
+	for t := sched.Next(last.In(loc)); !t.IsZero() && !t.After(now); t = sched.Next(t) {
+		if len(times) == max {
+			times = times[1:]
+		}
+		times = append(times, t)
+	}
+	return times
+}
+
+type scheduleEntry struct {
+	name     string
+	schedule Schedule
+	cron     cron.Schedule
+	loc      *time.Location
+	load     func() (*dagTask, error)
+	lock     sync.Mutex
+	running  bool
+	// scheduled times waiting for the running one
+	queue []time.Time
+}
+
+// Scheduler runs workflows at times of their schedules. Each run creates the
+// dag again, its run date is the scheduled time and its run id is the name of
+// the workflow followed by the scheduled time.
+type Scheduler struct {
+	entries []*scheduleEntry
+	names   map[string]bool
+	// run states and last scheduled times are saved here if it's set
+	stateDir string
+	store    StateStore
+	lock     sync.Mutex
+	// last scheduled time of workflows
+	last     map[string]time.Time
+	onFinish func(name string, result *RunResult, err error)
+	wg       sync.WaitGroup
+	logger   Logger
+	metrics  *Metrics
+}
+
+func NewScheduler() *Scheduler {
+	return &Scheduler{
+		names:  map[string]bool{},
+		last:   map[string]time.Time{},
+		logger: defaultLogger,
+	}
+}
+
+// SetMetrics makes runs instrumented by m, labeled by names of workflows
+func (s *Scheduler) SetMetrics(m *Metrics) {
+	s.metrics = m
+}
+
+// SetLogger sets the logger of the scheduler and of dags it runs
+func (s *Scheduler) SetLogger(l Logger) {
+	if l == nil {
+		l = NopLogger()
+	}
+	s.logger = l
+}
+
+const schedulerStateFile = "scheduler.json"
+
+// SetStateDir saves run states and the last scheduled time of each workflow
+// to dir, the latter is needed to catch up runs missed while the scheduler
+// was down
+func (s *Scheduler) SetStateDir(dir string) error {
+	store, err := NewFileStateStore(dir)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, schedulerStateFile))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	last := map[string]time.Time{}
+	if err == nil {
+		if err := json.Unmarshal(data, &last); err != nil {
+			return errors.Wrapf(err, "bad scheduler state %s", dir)
+		}
+	}
+	s.stateDir = dir
+	s.store = store
+	s.last = last
+	return nil
+}
+
+// OnFinish sets the function called after each run
+func (s *Scheduler) OnFinish(fn func(name string, result *RunResult, err error)) {
+	s.onFinish = fn
+}
+
+// Add adds a workflow, load is called to create the dag for each run
+func (s *Scheduler) Add(name string, schedule Schedule, load func() (*dagTask, error)) error {
+	if !patRunID.MatchString(name) {
+		return errors.Errorf("bad workflow name %q", name)
+	}
+	if s.names[name] {
+		return errors.Errorf("workflow %s added already", name)
+	}
+	sched, loc, err := schedule.parse()
+	if err != nil {
+		return errors.Wrapf(err, "workflow %s", name)
+	}
+	if schedule.Overlap == "" {
+		schedule.Overlap = OverlapSkip
+	}
+	s.names[name] = true
+	s.entries = append(s.entries, &scheduleEntry{
+		name:     name,
+		schedule: schedule,
+		cron:     sched,
+		loc:      loc,
+		load:     load,
+	})
+	return nil
+}
+
+// AddWorkflow adds a workflow file with a schedule, it's named by the file
+// name without extension. The file is loaded again for each run so that
+// changes of tasks take effect without restarting.
+func (s *Scheduler) AddWorkflow(path string) error {
+	c, err := LoadWorkflowConfig(path)
+	if err != nil {
+		return err
+	}
+	if c.Schedule == nil {
+		return &WorkflowError{File: path, Err: errors.New("no schedule")}
+	}
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	return s.Add(name, *c.Schedule, func() (*dagTask, error) {
+		return LoadWorkflow(path)
+	})
+}
+
+// Run runs workflows until ctx is done, running tasks are cancelled then and
+// it waits for running dags to finish, queued runs are dropped
+func (s *Scheduler) Run(ctx context.Context) error {
+	if len(s.entries) == 0 {
+		return errors.New("no workflow to schedule")
+	}
+	now := time.Now()
+	for _, e := range s.entries {
+		s.lock.Lock()
+		last, ok := s.last[e.name]
+		s.lock.Unlock()
+		if !ok {
+			// so that runs missed from now on can be caught up
+			s.setLast(e.name, now)
+		}
+		for _, t := range missedTimes(e.cron, e.loc, last, now, e.schedule.MaxCatchUp) {
+			s.logger.Info("catch up missed run", F("workflow", e.name), F("scheduled", t))
+			s.trigger(ctx, e, t, true)
+		}
+		s.wg.Add(1)
+		go func(e *scheduleEntry) {
+			defer s.wg.Done()
+			s.loop(ctx, e)
+		}(e)
+	}
+	<-ctx.Done()
+	s.wg.Wait()
+	return nil
+}
+
+func (s *Scheduler) loop(ctx context.Context, e *scheduleEntry) {
+	for {
+		next := e.cron.Next(time.Now().In(e.loc))
+		if next.IsZero() {
+			return
+		}
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+			s.trigger(ctx, e, next, false)
+		}
+	}
+}
+
+// trigger starts a run of e, or queues it if e is running and the overlap
+// policy or queue says so
+func (s *Scheduler) trigger(ctx context.Context, e *scheduleEntry, scheduled time.Time, queue bool) {
+	s.setLast(e.name, scheduled)
+	e.lock.Lock()
+	if e.running {
+		if queue || e.schedule.Overlap == OverlapQueue {
+			e.queue = append(e.queue, scheduled)
+		} else {
+			s.logger.Warn("skip run since the last one is running",
+				F("workflow", e.name), F("scheduled", scheduled))
+		}
+		e.lock.Unlock()
+		return
+	}
+	e.running = true
+	e.lock.Unlock()
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for {
+			s.runOnce(ctx, e, scheduled)
+			e.lock.Lock()
+			if len(e.queue) == 0 || ctx.Err() != nil {
+				e.queue = nil
+				e.running = false
+				e.lock.Unlock()
+				return
+			}
+			scheduled = e.queue[0]
+			e.queue = e.queue[1:]
+			e.lock.Unlock()
+		}
+	}()
+}
+
+func (s *Scheduler) runOnce(ctx context.Context, e *scheduleEntry, scheduled time.Time) {
+	d, err := e.load()
+	if err != nil {
+		s.logger.Error("load workflow failed", F("workflow", e.name), F("error", err))
+		if s.onFinish != nil {
+			s.onFinish(e.name, nil, err)
+		}
+		return
+	}
+	d.SetRunDate(scheduled)
+	d.SetRunID(e.name + "-" + newRunID(scheduled))
+	if s.store != nil {
+		d.SetStateStore(s.store)
+	}
+	d.SetLogger(s.logger)
+	if s.metrics != nil {
+		d.SetMetrics(s.metrics, e.name)
+	}
+	s.logger.Info("run workflow", F("workflow", e.name), F("scheduled", scheduled))
+	err = d.RunContext(ctx)
+	if err != nil {
+		s.logger.Error("workflow failed", F("workflow", e.name), F("error", err))
+	}
+	if s.onFinish != nil {
+		s.onFinish(e.name, d.Result(), err)
+	}
+}
+
+func (s *Scheduler) setLast(name string, t time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.last[name] = t
+	if s.stateDir == "" {
+		return
+	}
+	data, err := json.MarshalIndent(s.last, "", "  ")
+	if err == nil {
+		path := filepath.Join(s.stateDir, schedulerStateFile)
+		tmp := path + ".tmp"
+		if err = ioutil.WriteFile(tmp, data, 0644); err == nil {
+			err = os.Rename(tmp, path)
+		}
+	}
+	if err != nil {
+		s.logger.Warn("save scheduler state failed", F("error", err))
+	}
+}
@@ -0,0 +1,172 @@
+package task
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMissedTimes(t *testing.T) {
+	s := Schedule{Cron: "0 * * * *", Timezone: "Asia/Shanghai"}
+	sched, loc, err := s.parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := time.Date(2020, 1, 1, 10, 30, 0, 0, loc)
+	now := time.Date(2020, 1, 1, 14, 10, 0, 0, loc)
+	times := missedTimes(sched, loc, last, now, 2)
+	if len(times) != 2 || times[0].Hour() != 13 || times[1].Hour() != 14 {
+		t.Fatalf("unexpected missed times %v", times)
+	}
+	if times := missedTimes(sched, loc, last, now, 0); times != nil {
+		t.Fatalf("expect no catch-up, got %v", times)
+	}
+}
+
+func TestNewSchedule(t *testing.T) {
+	s, err := newSchedule("@daily")
+	if err != nil || s.Cron != "@daily" {
+		t.Fatalf("unexpected schedule %v, %v", s, err)
+	}
+	bad := []interface{}{
+		"* * *",
+		map[string]interface{}{"cron": "@daily", "timezone": "Mars/Base"},
+		map[string]interface{}{"cron": "@daily", "overlap": "kill"},
+		map[string]interface{}{"cron": "@daily", "every": "1h"},
+		1,
+	}
+	for _, v := range bad {
+		if _, err := newSchedule(v); err == nil {
+			t.Fatalf("expect error of %v", v)
+		}
+	}
+	path := writeWorkflow(t, "wf.yaml", `
+schedule:
+  cron: "*/5 * * * *"
+  overlap: queue
+  maxCatchUp: 2
+tasks:
+  - name: a
+    type: echo
+    echostr: a
+`)
+	c, err := LoadWorkflowConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Schedule{Cron: "*/5 * * * *", Overlap: OverlapQueue, MaxCatchUp: 2}
+	if c.Schedule == nil || *c.Schedule != expected {
+		t.Fatalf("unexpected schedule %v", c.Schedule)
+	}
+}
+
+type runRecorder struct {
+	lock   sync.Mutex
+	runIDs []string
+}
+
+func (r *runRecorder) record(name string, result *RunResult, err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.runIDs = append(r.runIDs, result.RunID)
+}
+
+func loadSleepDag() (*dagTask, error) {
+	return CreateTaskDag(DagTaskConfig{
+		Tasks: []map[string]interface{}{
+			{"name": "sleep", "type": "sh", "shellcmd": "sleep 0.2"},
+		},
+	})
+}
+
+func TestSchedulerOverlap(t *testing.T) {
+	for policy, runs := range map[OverlapPolicy]int{OverlapSkip: 1, OverlapQueue: 3} {
+		s := NewScheduler()
+		r := &runRecorder{}
+		s.OnFinish(r.record)
+		if err := s.Add("wf", Schedule{Cron: "@hourly", Overlap: policy}, loadSleepDag); err != nil {
+			t.Fatal(err)
+		}
+		now := time.Now()
+		for i := 0; i < 3; i++ {
+			s.trigger(context.Background(), s.entries[0], now.Add(time.Duration(i)*time.Second), false)
+		}
+		s.wg.Wait()
+		if len(r.runIDs) != runs {
+			t.Fatalf("%s: expect %d runs, got %v", policy, runs, r.runIDs)
+		}
+	}
+}
+
+func TestSchedulerCancel(t *testing.T) {
+	s := NewScheduler()
+	var states map[string]TaskState
+	s.OnFinish(func(name string, result *RunResult, err error) {
+		states = result.States()
+	})
+	load := func() (*dagTask, error) {
+		return CreateTaskDag(DagTaskConfig{
+			Tasks: []map[string]interface{}{
+				{"name": "sleep", "type": "sh", "shellcmd": "sleep 10"},
+			},
+		})
+	}
+	if err := s.Add("wf", Schedule{Cron: "@hourly"}, load); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	start := time.Now()
+	s.trigger(ctx, s.entries[0], start, false)
+	time.AfterFunc(200*time.Millisecond, cancel)
+	s.wg.Wait()
+	if time.Since(start) > 5*time.Second {
+		t.Fatal("running dag should be cancelled with the scheduler")
+	}
+	if states["sleep"] != StateCancelled {
+		t.Fatalf("unexpected states %v", states)
+	}
+}
+
+func TestSchedulerCatchUp(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	last := map[string]time.Time{"wf": now.Add(-3 * time.Hour)}
+	data, _ := json.Marshal(last)
+	if err := ioutil.WriteFile(filepath.Join(dir, schedulerStateFile), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	s := NewScheduler()
+	if err := s.SetStateDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	r := &runRecorder{}
+	s.OnFinish(r.record)
+	if err := s.Add("wf", Schedule{Cron: "0 * * * *", Timezone: "UTC", MaxCatchUp: 2}, loadSleepDag); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 600*time.Millisecond)
+	defer cancel()
+	s.Run(ctx)
+	if len(r.runIDs) != 2 {
+		t.Fatalf("expect 2 runs caught up, got %v", r.runIDs)
+	}
+	// runs are named by scheduled times
+	hour := now.Truncate(time.Hour)
+	expected := "wf-" + newRunID(hour.Add(-time.Hour))
+	if r.runIDs[0] != expected {
+		t.Fatalf("expect run %s, got %s", expected, r.runIDs[0])
+	}
+	state, err := s.store.Load(r.runIDs[1])
+	if err != nil || state == nil || state.Tasks["sleep"] != StateSucceeded {
+		t.Fatalf("unexpected run state %v, %v", state, err)
+	}
+	data, _ = ioutil.ReadFile(filepath.Join(dir, schedulerStateFile))
+	json.Unmarshal(data, &last)
+	if !last["wf"].Equal(hour) {
+		t.Fatalf("expect last scheduled time %v, got %v", hour, last["wf"])
+	}
+}
@@ -0,0 +1,223 @@
+package task
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/zxdvd/go-libs/std-helper/fs"
+)
+
+// Sleep waits for d or until ctx is done. The slot of the RunnerPool held by
+// the running task is released while sleeping so that other tasks can run,
+// tasks waiting for something should sleep with it. The slot is taken back by
+// waiting in the queue of the pool, which is not ordered by priorities of
+// tasks, and the waiter goes before ready tasks not started yet.
+func Sleep(ctx context.Context, d time.Duration) error {
+	slot, _ := ctx.Value(poolSlotKey{}).(*poolSlot)
+	if slot != nil {
+		slot.release()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+	}
+	if slot != nil {
+		return slot.acquire(ctx)
+	}
+	return nil
+}
+
+// WaitTask polls until a condition holds. The condition is one of
+//
+//	file: PATH              the file exists
+//	sql: QUERY              the query returns rows, conn or dialect and uri
+//	                        are needed as the sql task
+//	url: URL                GET of the url returns 200, expectStatus and tls
+//	                        options of the http task are supported
+//	time: HH:mm[:ss]        the time of today is reached, in timezone if set
+//
+// It polls every pollInterval, 30s by default, and fails if the condition
+// doesn't hold in waitTimeout if it's set.
+type WaitTask struct {
+	baseTask
+	// description of the condition
+	what     string
+	poke     func(ctx context.Context) (bool, error)
+	interval time.Duration
+	timeout  time.Duration
+	// connection of the dag used by sql
+	conn string
+}
+
+type WaitConfig struct {
+	File         string            `task:"file" desc:"wait until the file exists"`
+	Sql          string            `task:"sql" desc:"wait until the query returns rows"`
+	Conn         string            `task:"conn" desc:"name of a connection of the dag of sql"`
+	Dialect      string            `task:"dialect" desc:"name of the database/sql driver of sql"`
+	Uri          string            `task:"uri" desc:"data source name of sql"`
+	URL          string            `task:"url" desc:"wait until GET of the url returns an expected status"`
+	ExpectStatus []int             `task:"expectStatus" default:"200" desc:"expected status codes of url"`
+	Headers      map[string]string `task:"headers" desc:"request headers of url"`
+	Time         string            `task:"time" desc:"wait until the time of today, HH:mm[:ss]"`
+	Timezone     string            `task:"timezone" desc:"timezone of time, local by default"`
+	PollInterval time.Duration     `task:"pollInterval" default:"30s" desc:"interval between pokes"`
+	WaitTimeout  time.Duration     `task:"waitTimeout" desc:"fail if the condition doesn't hold in it"`
+	HttpClientConfig
+}
+
+func newWaitTask(name string, config interface{}) (Task, error) {
+	c, ok := config.(*WaitConfig)
+	if !ok {
+		return nil, errors.New("failed to newWaitTask, wrong config")
+	}
+	t := &WaitTask{interval: c.PollInterval, timeout: c.WaitTimeout, conn: c.Conn}
+	t.name = name
+	if t.interval <= 0 {
+		return nil, &ConfigError{Task: name, Fields: []*FieldError{
+			{Field: "pollInterval", Err: fmt.Errorf("should be positive, got %s", t.interval)},
+		}}
+	}
+	var conditions []string
+	for cond, value := range map[string]string{"file": c.File, "sql": c.Sql, "url": c.URL, "time": c.Time} {
+		if value != "" {
+			conditions = append(conditions, cond)
+		}
+	}
+	if len(conditions) != 1 {
+		sort.Strings(conditions)
+		return nil, fmt.Errorf("expect one of file, sql, url and time, got %v", conditions)
+	}
+	var err error
+	switch conditions[0] {
+	case "file":
+		t.what = "file " + c.File
+		t.poke = func(context.Context) (bool, error) {
+			return fs.Exists(c.File), nil
+		}
+	case "sql":
+		t.what = "rows of " + c.Sql
+		t.poke, err = sqlPoke(c)
+	case "url":
+		t.what = "url " + c.URL
+		t.poke, err = urlPoke(c)
+	case "time":
+		t.what = c.Time
+		t.poke, err = timePoke(c)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// connection returns the name of the connection of the dag used by t
+func (t *WaitTask) connection() string {
+	return t.conn
+}
+
+func sqlPoke(c *WaitConfig) (func(context.Context) (bool, error), error) {
+	if (c.Conn == "") == (c.Dialect == "" || c.Uri == "") {
+		return nil, errors.New("conn or dialect and uri are needed by sql")
+	}
+	t := &SqlTask{conn: c.Conn, dialect: c.Dialect, uri: c.Uri}
+	return func(ctx context.Context) (bool, error) {
+		db, release, err := t.db(ctx)
+		if err != nil {
+			return false, err
+		}
+		defer release()
+		rows, err := db.QueryContext(ctx, c.Sql)
+		if err != nil {
+			return false, err
+		}
+		defer rows.Close()
+		found := rows.Next()
+		return found, rows.Err()
+	}, nil
+}
+
+func urlPoke(c *WaitConfig) (func(context.Context) (bool, error), error) {
+	client, err := newHttpClient(&c.HttpClientConfig)
+	if err != nil {
+		return nil, err
+	}
+	h := &HttpTask{headers: c.Headers, expectStatus: c.ExpectStatus}
+	return func(ctx context.Context) (bool, error) {
+		req, err := http.NewRequest(http.MethodGet, c.URL, nil)
+		if err != nil {
+			return false, err
+		}
+		for k, v := range c.Headers {
+			req.Header.Set(k, v)
+		}
+		resp, err := client.Do(req.WithContext(ctx))
+		if err != nil {
+			// the endpoint may be not up yet
+			fmt.Fprintln(Output(ctx), err)
+			return false, ctx.Err()
+		}
+		resp.Body.Close()
+		return h.expected(resp.StatusCode), nil
+	}, nil
+}
+
+func timePoke(c *WaitConfig) (func(context.Context) (bool, error), error) {
+	var at time.Time
+	var err error
+	for _, layout := range []string{"15:04:05", "15:04"} {
+		if at, err = time.Parse(layout, c.Time); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("bad time %s, should be HH:mm or HH:mm:ss", c.Time)
+	}
+	loc := time.Local
+	if c.Timezone != "" {
+		if loc, err = time.LoadLocation(c.Timezone); err != nil {
+			return nil, errors.Wrap(err, "bad timezone")
+		}
+	}
+	return func(context.Context) (bool, error) {
+		now := time.Now().In(loc)
+		y, m, d := now.Date()
+		target := time.Date(y, m, d, at.Hour(), at.Minute(), at.Second(), 0, loc)
+		return !now.Before(target), nil
+	}, nil
+}
+
+func (t *WaitTask) Run(ctx context.Context) error {
+	waitCtx := ctx
+	if t.timeout > 0 {
+		var cancel context.CancelFunc
+		waitCtx, cancel = context.WithTimeout(ctx, t.timeout)
+		defer cancel()
+	}
+	out := Output(ctx)
+	fmt.Fprintf(out, "wait for %s\n", t.what)
+	start := time.Now()
+	for pokes := 1; ; pokes++ {
+		ok, err := t.poke(waitCtx)
+		if err == nil && ok {
+			fmt.Fprintf(out, "got %s after %d pokes in %s\n", t.what, pokes,
+				time.Since(start).Round(time.Millisecond))
+			return nil
+		}
+		if err == nil {
+			err = Sleep(waitCtx, t.interval)
+		}
+		if err != nil {
+			if ctx.Err() == nil && waitCtx.Err() == context.DeadlineExceeded {
+				return fmt.Errorf("no %s in %s", t.what, t.timeout)
+			}
+			return err
+		}
+	}
+}
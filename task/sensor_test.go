@@ -0,0 +1,84 @@
+package task
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWaitTask(t *testing.T) {
+	dir := t.TempDir()
+	var hits int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&hits, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+	path := filepath.Join(dir, "ready")
+	d, err := CreateTaskDag(DagTaskConfig{
+		// sensors give up the only slot while waiting
+		ConcurrentLimit: 1,
+		Mode:            ContinueOnError,
+		Tasks: []map[string]interface{}{
+			{"name": "file", "type": "wait", "file": path, "pollInterval": "50ms", "waitTimeout": "5s"},
+			{"name": "url", "type": "wait", "url": server.URL, "pollInterval": "50ms"},
+			{"name": "time", "type": "wait", "time": "00:00", "timezone": "UTC"},
+			{"name": "never", "type": "wait", "file": filepath.Join(dir, "never"),
+				"pollInterval": "50ms", "waitTimeout": "300ms"},
+			{"name": "touch", "type": "sh", "shellcmd": "sleep 0.2; touch " + path},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	d.Run()
+	expected := map[string]TaskState{
+		"file":  StateSucceeded,
+		"url":   StateSucceeded,
+		"time":  StateSucceeded,
+		"never": StateFailed,
+		"touch": StateSucceeded,
+	}
+	if states := taskStates(d); !equalStates(states, expected) {
+		t.Fatalf("expect states %v, got %v", expected, states)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("sensors should not block the pool, took %s", elapsed)
+	}
+	for _, tr := range d.Result().Tasks {
+		if tr.Name == "never" && tr.Error != "no file "+filepath.Join(dir, "never")+" in 300ms" {
+			t.Fatalf("unexpected error %s", tr.Error)
+		}
+	}
+}
+
+func TestNewWaitTask(t *testing.T) {
+	bad := []map[string]interface{}{
+		{"name": "a"},
+		{"name": "a", "file": "x", "url": "http://localhost"},
+		{"name": "a", "time": "25:00"},
+		{"name": "a", "sql": "select 1"},
+		{"name": "a", "file": "x", "pollInterval": "0s"},
+	}
+	for _, conf := range bad {
+		if _, err := newTask("wait", conf); err == nil {
+			t.Fatalf("expect error of %v", conf)
+		}
+	}
+	// connections are checked when the dag is created
+	_, err := CreateTaskDag(DagTaskConfig{
+		Connections: map[string]*Connection{"db": {Dialect: "sqlite3", Uri: ":memory:"}},
+		Tasks: []map[string]interface{}{
+			{"name": "a", "type": "wait", "sql": "select 1", "conn": "missing"},
+		},
+	})
+	if err == nil || !strings.Contains(err.Error(), "unknown connection missing") {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
@@ -0,0 +1,274 @@
+package task
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/zxdvd/go-libs/std-helper/shlex"
+)
+
+// ShellTask runs shellcmd with the shell, or runs argv directly. Config fields
+// are
+//
+//	shellcmd            command run by the shell with -c
+//	shell               the shell with its options, sh by default, like
+//	                    "bash -eo pipefail"
+//	argv                program and arguments run without a shell, instead
+//	                    of shellcmd
+//	shellcwd            working directory
+//	env                 mapping of environment variables
+//	envMode             inherit: variables of gotask and env, by default
+//	                    clear: only env
+//	                    merge: like inherit, $VAR in values of env are
+//	                    replaced by variables of gotask, like $PATH
+//	stdin               string written to stdin
+//	stdinFile           file read as stdin, relative to shellcwd
+//	allowExitCodes      exit codes treated as success besides 0
+//	logOutput           log each line of stdout and stderr while running
+//
+// It publishes the last maxStdoutOutput bytes of the stdout as output
+// "stdout" and the exit code as "exitCode", -1 if it's unknown, and more
+// outputs can be published by writing key=value lines to the file named by
+// env TASK_OUTPUT.
+// Variables like {name} and ${ds} in shellcmd are replaced before it runs,
+// "{{" is the escape of "{" like ${{name} for the shell variable.
+type ShellTask struct {
+	baseTask
+	cmd            string
+	argv           []string
+	cwd            string
+	env            map[string]string
+	envMode        string
+	stdin          string
+	stdinFile      string
+	allowExitCodes []int
+	logOutput      bool
+}
+
+// max bytes of the stdout kept for output "stdout"
+var maxStdoutOutput = 1 << 20
+
+// time Wait waits for pipes after the command exits, they may be kept open
+// by processes it starts in the background
+var shellWaitDelay = 5 * time.Second
+
+type ShellConfig struct {
+	Shellcmd       string            `task:"shellcmd" desc:"command run by the shell with -c"`
+	Shell          string            `task:"shell" default:"sh" desc:"the shell with its options, like bash -eo pipefail"`
+	Argv           []string          `task:"argv" desc:"program and arguments run without a shell, instead of shellcmd"`
+	Shellcwd       string            `task:"shellcwd" desc:"working directory of the command"`
+	Env            map[string]string `task:"env" desc:"environment variables"`
+	EnvMode        string            `task:"envMode" default:"inherit" enum:"inherit|clear|merge" desc:"inherit variables of gotask, clear them, or merge them into values of env by $VAR"`
+	Stdin          string            `task:"stdin" desc:"string written to stdin"`
+	StdinFile      string            `task:"stdinFile" desc:"file read as stdin, relative to shellcwd"`
+	AllowExitCodes []int             `task:"allowExitCodes" desc:"exit codes treated as success besides 0"`
+	LogOutput      bool              `task:"logOutput" desc:"log each line of stdout and stderr while running"`
+}
+
+// NewShellTask creates a ShellTask from a config map
+func NewShellTask(data ...interface{}) (Task, error) {
+	conf, ok := data[0].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("failed to newShellTask, wrong config")
+	}
+	return newTask("sh", conf)
+}
+
+func newShellTask(name string, config interface{}) (Task, error) {
+	c, ok := config.(*ShellConfig)
+	if !ok {
+		return nil, errors.New("failed to newShellTask, wrong config")
+	}
+	t := &ShellTask{
+		baseTask: baseTask{
+			name: name,
+		},
+		cmd:            c.Shellcmd,
+		argv:           c.Argv,
+		cwd:            c.Shellcwd,
+		env:            c.Env,
+		envMode:        c.EnvMode,
+		stdin:          c.Stdin,
+		stdinFile:      c.StdinFile,
+		allowExitCodes: c.AllowExitCodes,
+		logOutput:      c.LogOutput,
+	}
+	errs := &ConfigError{Task: name}
+	if (c.Shellcmd == "") == (len(c.Argv) == 0) {
+		errs.add("shellcmd", errors.New("expect one of shellcmd and argv"))
+	}
+	if c.Shellcmd != "" {
+		shell := strings.Fields(c.Shell)
+		if len(shell) == 0 {
+			errs.add("shell", errors.New("empty"))
+		}
+		t.argv = append(shell, "-c", c.Shellcmd)
+	}
+	for k := range c.Env {
+		if k == "" || strings.Contains(k, "=") {
+			errs.add("env", fmt.Errorf("bad name %q", k))
+		}
+	}
+	if c.Stdin != "" && c.StdinFile != "" {
+		errs.add("stdinFile", errors.New("conflicts with stdin"))
+	}
+	if err := errs.err(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// display returns the command, arguments of argv are quoted for the shell
+func (t *ShellTask) display() string {
+	if t.cmd != "" {
+		return t.cmd
+	}
+	quoted := make([]string, len(t.argv))
+	for i, arg := range t.argv {
+		quoted[i] = shlex.Quote(arg)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// environ returns environment variables of the command
+func (t *ShellTask) environ(outputFile string) []string {
+	var env []string
+	if t.envMode != "clear" {
+		env = os.Environ()
+	}
+	names := make([]string, 0, len(t.env))
+	for name := range t.env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value := t.env[name]
+		if t.envMode == "merge" {
+			value = os.ExpandEnv(value)
+		}
+		// the last one wins if a variable is duplicated
+		env = append(env, name+"="+value)
+	}
+	return append(env, "TASK_OUTPUT="+outputFile)
+}
+
+func (t *ShellTask) openStdin() (io.ReadCloser, error) {
+	if t.stdin != "" {
+		return ioutil.NopCloser(strings.NewReader(t.stdin)), nil
+	}
+	if t.stdinFile == "" {
+		return nil, nil
+	}
+	path := t.stdinFile
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(t.cwd, path)
+	}
+	return os.Open(path)
+}
+
+func (t *ShellTask) Run(ctx context.Context) error {
+	logger := TaskLogger(ctx)
+	logger.Debug("run command", F("cmd", t.display()))
+	command := exec.Command(t.argv[0], t.argv[1:]...)
+	command.Dir = t.cwd
+	out := Output(ctx)
+	stdout := newTailBuffer(maxStdoutOutput)
+	command.Stdout = io.MultiWriter(out, stdout)
+	command.Stderr = out
+	if t.logOutput {
+		logLines := func(stream string) io.WriteCloser {
+			w, _ := LineSink(func(_, line string) {
+				logger.Info("output", F("stream", stream), F("line", line))
+			}).Open(t.Name(), 0)
+			return w
+		}
+		stdoutLog, stderrLog := logLines("stdout"), logLines("stderr")
+		defer stdoutLog.Close()
+		defer stderrLog.Close()
+		command.Stdout = io.MultiWriter(command.Stdout, stdoutLog)
+		command.Stderr = io.MultiWriter(out, stderrLog)
+	}
+	stdin, err := t.openStdin()
+	if err != nil {
+		return errors.Wrap(err, "open stdin")
+	}
+	if stdin != nil {
+		defer stdin.Close()
+		command.Stdin = stdin
+	}
+	outputFile, err := ioutil.TempFile("", "task-output")
+	if err != nil {
+		return err
+	}
+	outputFile.Close()
+	defer os.Remove(outputFile.Name())
+	command.Env = t.environ(outputFile.Name())
+	command.WaitDelay = shellWaitDelay
+	// run in a new process group so that children are killed on cancel too
+	setProcessGroup(command)
+	if err := command.Start(); err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			killProcessGroup(command)
+		case <-done:
+		}
+	}()
+	err = command.Wait()
+	close(done)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	code := -1
+	if command.ProcessState != nil {
+		code = command.ProcessState.ExitCode()
+	}
+	SetOutput(ctx, "exitCode", strconv.Itoa(code))
+	if _, ok := err.(*exec.ExitError); ok {
+		for _, allowed := range t.allowExitCodes {
+			if code == allowed {
+				err = nil
+			}
+		}
+	}
+	if err == exec.ErrWaitDelay {
+		return errors.Wrap(err, "output is kept open by background processes")
+	}
+	if err != nil {
+		return err
+	}
+	SetOutput(ctx, "stdout", strings.TrimRight(stdout.String(), "\n"))
+	return readOutputFile(ctx, outputFile.Name())
+}
+
+// readOutputFile publishes key=value lines of the file as outputs
+func readOutputFile(ctx context.Context, path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("bad output line %q, should be key=value", line)
+		}
+		SetOutput(ctx, strings.TrimSpace(kv[0]), kv[1])
+	}
+	return nil
+}
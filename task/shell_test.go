@@ -0,0 +1,110 @@
+package task
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestShellTask(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "input"), []byte("from file\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GOTASK_TEST_ENV", "parent")
+	defer func(size int, delay time.Duration) {
+		maxStdoutOutput, shellWaitDelay = size, delay
+	}(maxStdoutOutput, shellWaitDelay)
+	maxStdoutOutput, shellWaitDelay = 16, 100*time.Millisecond
+	d, err := CreateTaskDag(DagTaskConfig{
+		Mode:   ContinueOnError,
+		Params: map[string]string{"who": "world"},
+		Tasks: []map[string]interface{}{
+			{"name": "argv", "type": "sh", "argv": []interface{}{"printf", "%s|", "a b", "it's"}},
+			{"name": "inherit", "type": "sh", "shellcmd": "echo $GOTASK_TEST_ENV-$OWN",
+				"env": map[string]interface{}{"OWN": "own"}},
+			{"name": "clear", "type": "sh", "shellcmd": "echo \"$GOTASK_TEST_ENV-$OWN\"", "envMode": "clear",
+				"env": map[string]interface{}{"OWN": "own"}},
+			{"name": "merge", "type": "sh", "shellcmd": "echo $OWN", "envMode": "merge",
+				"env": map[string]interface{}{"OWN": "$GOTASK_TEST_ENV/own"}},
+			{"name": "stdin", "type": "sh", "shellcmd": "cat", "stdin": "hello {params.who}"},
+			{"name": "stdinFile", "type": "sh", "shellcmd": "cat", "stdinFile": "input", "shellcwd": dir},
+			{"name": "allowed", "type": "sh", "shellcmd": "echo partial; exit 3", "allowExitCodes": []interface{}{3}},
+			{"name": "failed", "type": "sh", "shellcmd": "exit 4", "allowExitCodes": []interface{}{3}},
+			{"name": "bash", "type": "sh", "shell": "bash -o pipefail", "shellcmd": "false | true"},
+			{"name": "log", "type": "sh", "shellcmd": "echo out; echo err >&2; printf last", "logOutput": true},
+			{"name": "tail", "type": "sh", "shellcmd": "seq 1 100"},
+			// the background process keeps stdout open after the shell exits
+			{"name": "background", "type": "sh", "shellcmd": "sleep 1 &"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msgs []string
+	d.SetLogger(recordLogger{lock: &sync.Mutex{}, msgs: &msgs})
+	d.SetOutputSink(NewMemorySink())
+	d.Run()
+	expected := map[string]string{
+		"argv":      "a b|it's|",
+		"inherit":   "parent-own",
+		"clear":     "-own",
+		"merge":     "parent/own",
+		"stdin":     "hello world",
+		"stdinFile": "from file",
+		"allowed":   "partial",
+		"tail":      "96\n97\n98\n99\n100",
+	}
+	for _, tr := range d.Result().Tasks {
+		switch tr.Name {
+		case "failed", "bash", "background":
+			codes := map[string]string{"failed": "4", "bash": "1", "background": "0"}
+			if tr.State != StateFailed || tr.Outputs["exitCode"] != codes[tr.Name] {
+				t.Errorf("task %s: unexpected result %s %v", tr.Name, tr.State, tr.Outputs)
+			}
+		case "log":
+		default:
+			if tr.State != StateSucceeded || tr.Outputs["stdout"] != expected[tr.Name] {
+				t.Errorf("task %s: unexpected result %s %s %v", tr.Name, tr.State, tr.Error, tr.Outputs)
+			}
+		}
+		if tr.Name == "allowed" && tr.Outputs["exitCode"] != "3" {
+			t.Errorf("unexpected exit code %v", tr.Outputs)
+		}
+	}
+
+	var lines, cmds []string
+	for _, msg := range msgs {
+		if strings.HasPrefix(msg, "output ") && strings.Contains(msg, "name=log") {
+			lines = append(lines, msg)
+		}
+		if strings.HasPrefix(msg, "run command name=argv") {
+			cmds = append(cmds, msg)
+		}
+	}
+	sort.Strings(lines)
+	if strings.Join(lines, "\n") != "output name=log stream=stderr line=err\n"+
+		"output name=log stream=stdout line=last\noutput name=log stream=stdout line=out" {
+		t.Fatalf("unexpected lines %q", lines)
+	}
+	if len(cmds) != 1 || !strings.HasSuffix(cmds[0], `cmd=printf '%s|' 'a b' 'it'"'"'s'`) {
+		t.Fatalf("unexpected commands %q", cmds)
+	}
+
+	bad := []map[string]interface{}{
+		{"name": "a", "type": "sh"},
+		{"name": "a", "type": "sh", "shellcmd": "true", "argv": []interface{}{"true"}},
+		{"name": "a", "type": "sh", "shellcmd": "true", "envMode": "keep"},
+		{"name": "a", "type": "sh", "shellcmd": "true", "env": map[string]interface{}{"A=B": "c"}},
+		{"name": "a", "type": "sh", "shellcmd": "cat", "stdin": "x", "stdinFile": "y"},
+	}
+	for _, tc := range bad {
+		if _, err := NewTask("sh", tc); err == nil {
+			t.Errorf("expect error of %v", tc)
+		}
+	}
+}
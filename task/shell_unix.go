@@ -0,0 +1,21 @@
+//go:build !windows
+// +build !windows
+
+package task
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+func killProcessGroup(cmd *exec.Cmd) {
+	if cmd.Process == nil {
+		return
+	}
+	// negative pid means the process group
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
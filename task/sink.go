@@ -0,0 +1,141 @@
+package task
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// OutputSink receives stdout and stderr of tasks. Without a sink tasks write
+// to os.Stdout, and tasks of sub dags write to the output of the parent task.
+type OutputSink interface {
+	// Open returns the writer of an attempt of a task, it's closed when the
+	// attempt finishes
+	Open(task string, attempt int) (io.WriteCloser, error)
+}
+
+// SetOutputSink sets the sink of outputs of tasks of the dag
+func (d *dagTask) SetOutputSink(sink OutputSink) {
+	d.sink = sink
+	for _, node := range d.Nodes() {
+		node.(*task).sink = sink
+	}
+}
+
+// FileSink appends outputs of each task to DIR/TASK.log
+type FileSink struct {
+	dir string
+}
+
+func NewFileSink(dir string) (*FileSink, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FileSink{dir: dir}, nil
+}
+
+// Path returns the log file of a task
+func (s *FileSink) Path(task string) string {
+	return filepath.Join(s.dir, strings.Replace(task, string(filepath.Separator), "_", -1)+".log")
+}
+
+func (s *FileSink) Open(task string, attempt int) (io.WriteCloser, error) {
+	f, err := os.OpenFile(s.Path(task), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if attempt > 1 {
+		fmt.Fprintf(f, "--- attempt %d\n", attempt)
+	}
+	return f, nil
+}
+
+// MemorySink keeps outputs of tasks in memory
+type MemorySink struct {
+	lock    sync.Mutex
+	outputs map[string]*bytes.Buffer
+}
+
+func NewMemorySink() *MemorySink {
+	return &MemorySink{outputs: map[string]*bytes.Buffer{}}
+}
+
+func (s *MemorySink) Open(task string, attempt int) (io.WriteCloser, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.outputs[task] == nil {
+		s.outputs[task] = &bytes.Buffer{}
+	}
+	return memoryWriter{s: s, buf: s.outputs[task]}, nil
+}
+
+// Output returns all output of a task
+func (s *MemorySink) Output(task string) string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if buf, ok := s.outputs[task]; ok {
+		return buf.String()
+	}
+	return ""
+}
+
+type memoryWriter struct {
+	s   *MemorySink
+	buf *bytes.Buffer
+}
+
+func (w memoryWriter) Write(p []byte) (int, error) {
+	w.s.lock.Lock()
+	defer w.s.lock.Unlock()
+	return w.buf.Write(p)
+}
+
+func (w memoryWriter) Close() error {
+	return nil
+}
+
+// LineSink calls fn with each line of outputs of tasks as it's written,
+// without the trailing newline. fn may be called concurrently for different
+// tasks.
+type LineSink func(task, line string)
+
+func (fn LineSink) Open(task string, attempt int) (io.WriteCloser, error) {
+	return &lineWriter{fn: fn, task: task}, nil
+}
+
+type lineWriter struct {
+	fn   LineSink
+	task string
+	lock sync.Mutex
+	// incomplete last line
+	buf []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.fn(w.task, string(w.buf[:i]))
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+func (w *lineWriter) Close() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if len(w.buf) > 0 {
+		w.fn(w.task, string(w.buf))
+		w.buf = nil
+	}
+	return nil
+}
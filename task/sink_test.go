@@ -0,0 +1,105 @@
+package task
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// recordLogger keeps messages with fields
+type recordLogger struct {
+	lock   *sync.Mutex
+	fields []Field
+	msgs   *[]string
+}
+
+func (r recordLogger) log(msg string, fields []Field) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, f := range append(r.fields, fields...) {
+		msg += fmt.Sprintf(" %s=%v", f.Key, f.Value)
+	}
+	*r.msgs = append(*r.msgs, msg)
+}
+
+func (r recordLogger) Debug(msg string, fields ...Field) { r.log(msg, fields) }
+func (r recordLogger) Info(msg string, fields ...Field)  { r.log(msg, fields) }
+func (r recordLogger) Warn(msg string, fields ...Field)  { r.log(msg, fields) }
+func (r recordLogger) Error(msg string, fields ...Field) { r.log(msg, fields) }
+
+func (r recordLogger) With(fields ...Field) Logger {
+	r.fields = append(append([]Field{}, r.fields...), fields...)
+	return r
+}
+
+func TestLoggerAndSinks(t *testing.T) {
+	dir := t.TempDir()
+	newDag := func() *dagTask {
+		d, err := CreateTaskDag(DagTaskConfig{
+			Mode: ContinueOnError,
+			Tasks: []map[string]interface{}{
+				{"name": "a", "type": "sh", "shellcmd": "echo one; printf two"},
+				{"name": "b", "type": "sh", "shellcmd": "echo bad >&2; exit 1", "retries": 1},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+
+	var msgs []string
+	d := newDag()
+	d.SetLogger(recordLogger{lock: &sync.Mutex{}, msgs: &msgs})
+	mem := NewMemorySink()
+	d.SetOutputSink(mem)
+	d.Run()
+	if mem.Output("a") != "one\ntwo" || mem.Output("b") != "bad\nbad\n" {
+		t.Fatalf("unexpected outputs %q %q", mem.Output("a"), mem.Output("b"))
+	}
+	found := false
+	for _, msg := range msgs {
+		if msg == "run command name=a cmd=echo one; printf two" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("unexpected logs %v", msgs)
+	}
+
+	var lock sync.Mutex
+	var lines []string
+	d = newDag()
+	d.SetLogger(nil)
+	d.SetOutputSink(LineSink(func(task, line string) {
+		lock.Lock()
+		defer lock.Unlock()
+		lines = append(lines, task+": "+line)
+	}))
+	d.Run()
+	sort.Strings(lines)
+	if strings.Join(lines, "|") != "a: one|a: two|b: bad|b: bad" {
+		t.Fatalf("unexpected lines %v", lines)
+	}
+
+	d = newDag()
+	d.SetLogger(nil)
+	sink, err := NewFileSink(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.SetOutputSink(sink)
+	d.Run()
+	data, err := ioutil.ReadFile(filepath.Join(dir, "b.log"))
+	if err != nil || string(data) != "bad\n--- attempt 2\nbad\n" {
+		t.Fatalf("unexpected file %q, %v", data, err)
+	}
+	// the result still has the tail of outputs
+	if out := d.Result().Tasks[0].Output; out != "one\ntwo" {
+		t.Fatalf("unexpected output %q", out)
+	}
+}
@@ -0,0 +1,741 @@
+package task
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Connection is a database shared by sql tasks of a dag, tasks use it by
+// name with conn
+type Connection struct {
+	Dialect         string        `task:"dialect,required" desc:"name of the database/sql driver"`
+	Uri             string        `task:"uri,required" desc:"data source name passed to the driver"`
+	MaxOpenConns    int           `task:"maxOpenConns" desc:"max number of open connections, 0 means unlimited"`
+	MaxIdleConns    int           `task:"maxIdleConns" desc:"max number of idle connections, 2 by default"`
+	ConnMaxLifetime time.Duration `task:"connMaxLifetime" desc:"max time a connection may be reused"`
+}
+
+// newConnection creates a Connection from its config in a workflow file
+func newConnection(name string, raw interface{}) (*Connection, error) {
+	conf, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("connection %s should be a mapping, got %v", name, raw)
+	}
+	c := &Connection{}
+	errs := &ConfigError{}
+	decodeConfig(conf, c, errs)
+	known := map[string]bool{}
+	for _, f := range configFields(reflect.TypeOf(*c)) {
+		known[f.key] = true
+	}
+	var unknown []string
+	for k := range conf {
+		if !known[k] {
+			unknown = append(unknown, k)
+		}
+	}
+	sort.Strings(unknown)
+	for _, k := range unknown {
+		errs.add(k, errors.New("unknown field"))
+	}
+	if err := errs.err(); err != nil {
+		return nil, errors.Wrapf(err, "connection %s", name)
+	}
+	return c, nil
+}
+
+// dbCache holds databases opened by sql tasks of a dag run, one for each
+// connection, or each dialect and uri of tasks without conn. Sub dags share
+// the cache of the top dag, and databases are closed when its run finishes.
+type dbCache struct {
+	lock sync.Mutex
+	m    map[dbKey]*sql.DB
+}
+
+type dbKey struct {
+	conn         *Connection
+	dialect, uri string
+}
+
+type dbCacheKey struct{}
+
+// runDBCache returns the cache of the parent run, or a new one which should
+// be closed when the run finishes
+func runDBCache(parent context.Context) (_ *dbCache, close func() error) {
+	if c, ok := parent.Value(dbCacheKey{}).(*dbCache); ok {
+		return c, func() error { return nil }
+	}
+	c := &dbCache{m: map[dbKey]*sql.DB{}}
+	return c, c.close
+}
+
+func withDBCache(ctx context.Context, c *dbCache) context.Context {
+	return context.WithValue(ctx, dbCacheKey{}, c)
+}
+
+func (c *dbCache) open(key dbKey) (*sql.DB, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if db, ok := c.m[key]; ok {
+		return db, nil
+	}
+	db, err := openDB(key)
+	if err != nil {
+		return nil, err
+	}
+	c.m[key] = db
+	return db, nil
+}
+
+func (c *dbCache) close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	var firstErr error
+	for key, db := range c.m {
+		if err := db.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(c.m, key)
+	}
+	return firstErr
+}
+
+func openDB(key dbKey) (*sql.DB, error) {
+	if key.conn == nil {
+		return sql.Open(key.dialect, key.uri)
+	}
+	c := key.conn
+	db, err := sql.Open(c.Dialect, c.Uri)
+	if err != nil {
+		return nil, err
+	}
+	if c.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(c.MaxOpenConns)
+	}
+	if c.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(c.MaxIdleConns)
+	}
+	if c.ConnMaxLifetime > 0 {
+		db.SetConnMaxLifetime(c.ConnMaxLifetime)
+	}
+	return db, nil
+}
+
+type connectionsKey struct{}
+
+// withConnections adds connections of a dag to those of the parent dag
+func withConnections(ctx context.Context, conns map[string]*Connection) context.Context {
+	if len(conns) == 0 {
+		return ctx
+	}
+	merged := map[string]*Connection{}
+	if parent, ok := ctx.Value(connectionsKey{}).(map[string]*Connection); ok {
+		for name, c := range parent {
+			merged[name] = c
+		}
+	}
+	for name, c := range conns {
+		merged[name] = c
+	}
+	return context.WithValue(ctx, connectionsKey{}, merged)
+}
+
+func connectionFrom(ctx context.Context, name string) (*Connection, bool) {
+	conns, _ := ctx.Value(connectionsKey{}).(map[string]*Connection)
+	c, ok := conns[name]
+	return c, ok
+}
+
+// SqlTask executes statements of sql or a script file, separated by
+// semicolons. Config fields are
+//
+//	conn                name of a connection of the dag, or
+//	dialect, uri        driver and data source name
+//	sql                 statements to execute
+//	file                script file to execute instead of sql
+//	args                parameters of statements with placeholders
+//	transaction         run all statements in a transaction
+//	sqloutput           publish the first value of the last statement as
+//	                    this output
+//	capture             mapping of output names to columns of the first row
+//	                    of the last statement
+//	resultFile          write rows of the last statement to this file
+//	resultFormat        csv or json, by the extension of resultFile by default
+//
+// Statements run on the same database connection. Placeholders, like ? or $1
+// outside quotes and comments, take args in order, each ? takes the next arg
+// and $1 to $N take the next N args of their statement. ? isn't a placeholder
+// of postgres, where it's an operator of jsonb. Named params like :a or @a
+// can't be counted, all args are given to each statement with placeholders if
+// any statement has them. The last statement is queried if any of sqloutput,
+// capture and resultFile is set.
+type SqlTask struct {
+	baseTask
+	conn         string
+	dialect      string
+	uri          string
+	Sql          string
+	file         string
+	args         []interface{}
+	transaction  bool
+	output       string
+	capture      map[string]string
+	resultFile   string
+	resultFormat string
+}
+
+type SqlConfig struct {
+	Conn         string            `task:"conn" desc:"name of a connection of the dag"`
+	Dialect      string            `task:"dialect" desc:"name of the database/sql driver, unless conn is set"`
+	Uri          string            `task:"uri" desc:"data source name passed to the driver, unless conn is set"`
+	Sql          string            `task:"sql" desc:"statements to execute, separated by semicolons"`
+	File         string            `task:"file" desc:"script file to execute instead of sql"`
+	Args         []interface{}     `task:"args" desc:"parameters of statements with placeholders"`
+	Transaction  bool              `task:"transaction" desc:"run all statements in a transaction"`
+	Sqloutput    string            `task:"sqloutput" desc:"publish the first value of the last statement as this output"`
+	Capture      map[string]string `task:"capture" desc:"output names to columns of the first row of the last statement"`
+	ResultFile   string            `task:"resultFile" desc:"write rows of the last statement to the file"`
+	ResultFormat string            `task:"resultFormat" enum:"csv|json" desc:"csv or json, by the extension of resultFile by default"`
+}
+
+func newSqlTask(name string, config interface{}) (Task, error) {
+	c, ok := config.(*SqlConfig)
+	if !ok {
+		return nil, errors.New("failed to newSqlTask, wrong config")
+	}
+	t := &SqlTask{
+		baseTask: baseTask{
+			name: name,
+		},
+		conn:         c.Conn,
+		dialect:      c.Dialect,
+		uri:          c.Uri,
+		Sql:          c.Sql,
+		file:         c.File,
+		args:         c.Args,
+		transaction:  c.Transaction,
+		output:       c.Sqloutput,
+		capture:      c.Capture,
+		resultFile:   c.ResultFile,
+		resultFormat: c.ResultFormat,
+	}
+	errs := &ConfigError{Task: name}
+	if c.Conn != "" && (c.Dialect != "" || c.Uri != "") {
+		errs.add("conn", errors.New("conflicts with dialect and uri"))
+	}
+	if c.Conn == "" {
+		if c.Dialect == "" {
+			errs.add("dialect", errors.New("missing"))
+		}
+		if c.Uri == "" {
+			errs.add("uri", errors.New("missing"))
+		}
+	}
+	if (c.Sql == "") == (c.File == "") {
+		errs.add("sql", errors.New("expect one of sql and file"))
+	}
+	if t.resultFormat == "" && t.resultFile != "" {
+		switch strings.ToLower(filepath.Ext(t.resultFile)) {
+		case ".csv":
+			t.resultFormat = "csv"
+		case ".json":
+			t.resultFormat = "json"
+		default:
+			errs.add("resultFormat", fmt.Errorf("unknown format of %s", t.resultFile))
+		}
+	}
+	if err := errs.err(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// connection returns the name of the connection of the dag used by t
+func (t *SqlTask) connection() string {
+	return t.conn
+}
+
+// db returns the database of t from the cache of the run, or a new one
+// outside of dag runs, release should be called after using it
+func (t *SqlTask) db(ctx context.Context) (db *sql.DB, release func(), err error) {
+	key := dbKey{dialect: t.dialect, uri: t.uri}
+	if t.conn != "" {
+		c, ok := connectionFrom(ctx, t.conn)
+		if !ok {
+			return nil, nil, fmt.Errorf("connection %s not found", t.conn)
+		}
+		key = dbKey{conn: c}
+	}
+	if cache, ok := ctx.Value(dbCacheKey{}).(*dbCache); ok {
+		db, err = cache.open(key)
+		return db, func() {}, err
+	}
+	if db, err = openDB(key); err != nil {
+		return nil, nil, err
+	}
+	return db, func() { db.Close() }, nil
+}
+
+// sqlExecer is a connection or a transaction
+type sqlExecer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+func (t *SqlTask) Run(ctx context.Context) (err error) {
+	script := t.Sql
+	if t.file != "" {
+		data, err := ioutil.ReadFile(t.file)
+		if err != nil {
+			return err
+		}
+		script = string(data)
+	}
+	driver := t.dialect
+	if t.conn != "" {
+		if c, ok := connectionFrom(ctx, t.conn); ok {
+			driver = c.Dialect
+		}
+	}
+	stmts := splitStatements(script, syntaxOf(driver))
+	if len(stmts) == 0 {
+		return errors.New("no statements to execute")
+	}
+	args, err := t.statementArgs(stmts)
+	if err != nil {
+		return err
+	}
+	db, release, err := t.db(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	var execer sqlExecer = conn
+	if t.transaction {
+		var tx *sql.Tx
+		if tx, err = conn.BeginTx(ctx, nil); err != nil {
+			return err
+		}
+		defer func() {
+			if err != nil {
+				tx.Rollback()
+				return
+			}
+			err = tx.Commit()
+		}()
+		execer = tx
+	}
+	query := t.output != "" || len(t.capture) > 0 || t.resultFile != ""
+	for i, stmt := range stmts {
+		TaskLogger(ctx).Debug("exec sql", F("statement", i+1), F("of", len(stmts)))
+		if query && i == len(stmts)-1 {
+			err = t.query(ctx, execer, stmt.text, args[i])
+		} else {
+			_, err = execer.ExecContext(ctx, stmt.text, args[i]...)
+		}
+		if err != nil {
+			if len(stmts) > 1 {
+				return errors.Wrapf(err, "statement %d", i+1)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// statementArgs gives out args in order to statements by the number of their
+// placeholders, all args should be taken. If any statement has named params,
+// all args are given to each statement with placeholders without checking.
+func (t *SqlTask) statementArgs(stmts []sqlStatement) ([][]interface{}, error) {
+	args := make([][]interface{}, len(stmts))
+	named := false
+	for _, stmt := range stmts {
+		named = named || stmt.params < 0
+	}
+	if named {
+		for i, stmt := range stmts {
+			if stmt.params != 0 {
+				args[i] = t.args
+			}
+		}
+		return args, nil
+	}
+	next := 0
+	for i, stmt := range stmts {
+		if next+stmt.params > len(t.args) {
+			return nil, fmt.Errorf("statement %d: expect %d args, only %d left", i+1, stmt.params, len(t.args)-next)
+		}
+		args[i] = t.args[next : next+stmt.params]
+		next += stmt.params
+	}
+	if next != len(t.args) {
+		return nil, fmt.Errorf("expect %d args by placeholders, got %d", next, len(t.args))
+	}
+	return args, nil
+}
+
+// query runs the last statement and handles its rows
+func (t *SqlTask) query(ctx context.Context, execer sqlExecer, query string, args []interface{}) error {
+	rows, err := execer.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	var w resultWriter
+	if t.resultFile != "" {
+		f, err := os.Create(t.resultFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if t.resultFormat == "csv" {
+			w = newCsvResultWriter(f, columns)
+		} else {
+			w = newJsonResultWriter(f, columns)
+		}
+	}
+	var first []interface{}
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		ptrs := make([]interface{}, len(columns))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return err
+		}
+		for i, v := range values {
+			values[i] = sqlValue(v)
+		}
+		if first == nil {
+			first = values
+		}
+		if w == nil {
+			break
+		}
+		if err := w.write(values); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if w != nil {
+		if err := w.close(); err != nil {
+			return err
+		}
+	}
+	if first == nil && (t.output != "" || len(t.capture) > 0) {
+		return sql.ErrNoRows
+	}
+	if t.output != "" {
+		SetOutput(ctx, t.output, sqlString(first[0]))
+	}
+	for output, column := range t.capture {
+		i := indexOf(columns, column)
+		if i < 0 {
+			return fmt.Errorf("capture %s: column %s not found", output, column)
+		}
+		SetOutput(ctx, output, sqlString(first[i]))
+	}
+	return nil
+}
+
+func indexOf(list []string, s string) int {
+	for i, item := range list {
+		if item == s {
+			return i
+		}
+	}
+	return -1
+}
+
+// sqlValue converts values scanned from drivers to values of json
+func sqlValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case []byte:
+		return string(v)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	}
+	return v
+}
+
+// sqlString formats a value of sqlValue, null is empty
+func sqlString(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+// resultWriter writes rows of a query to a file
+type resultWriter interface {
+	write(values []interface{}) error
+	close() error
+}
+
+type csvResultWriter struct {
+	w       *csv.Writer
+	record  []string
+	started bool
+	columns []string
+}
+
+func newCsvResultWriter(w io.Writer, columns []string) *csvResultWriter {
+	return &csvResultWriter{w: csv.NewWriter(w), columns: columns, record: make([]string, len(columns))}
+}
+
+func (w *csvResultWriter) header() error {
+	if w.started {
+		return nil
+	}
+	w.started = true
+	return w.w.Write(w.columns)
+}
+
+func (w *csvResultWriter) write(values []interface{}) error {
+	if err := w.header(); err != nil {
+		return err
+	}
+	for i, v := range values {
+		w.record[i] = sqlString(v)
+	}
+	return w.w.Write(w.record)
+}
+
+func (w *csvResultWriter) close() error {
+	if err := w.header(); err != nil {
+		return err
+	}
+	w.w.Flush()
+	return w.w.Error()
+}
+
+// jsonResultWriter writes a list of objects keyed by column names
+type jsonResultWriter struct {
+	w       io.Writer
+	columns []string
+	count   int
+}
+
+func newJsonResultWriter(w io.Writer, columns []string) *jsonResultWriter {
+	return &jsonResultWriter{w: w, columns: columns}
+}
+
+func (w *jsonResultWriter) write(values []interface{}) error {
+	row := make(map[string]interface{}, len(values))
+	for i, v := range values {
+		row[w.columns[i]] = v
+	}
+	data, err := json.Marshal(row)
+	if err != nil {
+		return err
+	}
+	sep := ",\n"
+	if w.count == 0 {
+		sep = "[\n"
+	}
+	w.count++
+	_, err = fmt.Fprintf(w.w, "%s  %s", sep, data)
+	return err
+}
+
+func (w *jsonResultWriter) close() error {
+	end := "\n]\n"
+	if w.count == 0 {
+		end = "[]\n"
+	}
+	_, err := io.WriteString(w.w, end)
+	return err
+}
+
+// sqlStatement is a statement of a script
+type sqlStatement struct {
+	text string
+	// number of args taken by placeholders outside quotes and comments,
+	// each ? takes one, and $N takes up to the largest N. It's -1 if the
+	// statement has named params like :a, they can't be counted.
+	params int
+}
+
+// sqlSyntax is the syntax of a driver that matters for splitting scripts
+type sqlSyntax struct {
+	// ? is a placeholder, it's an operator of jsonb in postgres
+	marks bool
+	// $N is a placeholder
+	numbered bool
+	// prefixes of named params, like : and @
+	named string
+	// backslashes escape quotes in strings, like 'it\'s' of mysql
+	backslash bool
+}
+
+// syntaxOf returns the syntax of the database/sql driver, unknown drivers are
+// like sqlite3
+func syntaxOf(driver string) sqlSyntax {
+	switch driver {
+	case "postgres", "pgx", "cloudsqlpostgres":
+		return sqlSyntax{numbered: true}
+	case "mysql":
+		// @a are user variables
+		return sqlSyntax{marks: true, backslash: true}
+	case "sqlserver", "mssql":
+		return sqlSyntax{marks: true, named: "@"}
+	case "godror", "oracle", "oci8":
+		return sqlSyntax{named: ":"}
+	}
+	return sqlSyntax{marks: true, numbered: true, named: ":@"}
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isIdentChar(c byte) bool {
+	return isIdentStart(c) || c >= '0' && c <= '9'
+}
+
+// splitStatements splits a script by semicolons outside quotes, comments and
+// dollar quoted strings of postgres. Statements of only comments are dropped.
+func splitStatements(script string, syntax sqlSyntax) []sqlStatement {
+	var stmts []sqlStatement
+	start, code, marks, numbered, named := 0, false, 0, 0, false
+	flush := func(end int) {
+		if code {
+			params := marks + numbered
+			if named {
+				params = -1
+			}
+			stmts = append(stmts, sqlStatement{text: strings.TrimSpace(script[start:end]), params: params})
+		}
+		code, marks, numbered, named = false, 0, 0, false
+	}
+	n := len(script)
+	for i := 0; i < n; {
+		c := script[i]
+		var next, prev byte
+		if i+1 < n {
+			next = script[i+1]
+		}
+		if i > 0 {
+			prev = script[i-1]
+		}
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			// doubled quotes are escaped ones
+			j := i + 1
+			for j < n {
+				if syntax.backslash && c != '`' && script[j] == '\\' {
+					j += 2
+					continue
+				}
+				if script[j] == c {
+					if j+1 < n && script[j+1] == c {
+						j += 2
+						continue
+					}
+					break
+				}
+				j++
+			}
+			code = true
+			i = j + 1
+		case c == '-' && next == '-':
+			for i < n && script[i] != '\n' {
+				i++
+			}
+		case c == '/' && next == '*':
+			end := strings.Index(script[i+2:], "*/")
+			if end < 0 {
+				i = n
+			} else {
+				i += end + 4
+			}
+		case c == '$' && syntax.numbered && next >= '0' && next <= '9':
+			code = true
+			j := i + 1
+			for j < n && script[j] >= '0' && script[j] <= '9' {
+				j++
+			}
+			if k, _ := strconv.Atoi(script[i+1 : j]); k > numbered {
+				numbered = k
+			}
+			i = j
+		case strings.IndexByte(syntax.named, c) >= 0 && c != prev && c != next &&
+			!isIdentChar(prev) && (isIdentStart(next) || c == ':' && next >= '0' && next <= '9'):
+			// not casts like a::int of postgres, or @@global of mysql
+			code, named = true, true
+			i++
+			for i < n && isIdentChar(script[i]) {
+				i++
+			}
+		case c == '$':
+			code = true
+			tag := dollarTag(script[i:])
+			if tag == "" {
+				i++
+				break
+			}
+			end := strings.Index(script[i+len(tag):], tag)
+			if end < 0 {
+				i = n
+			} else {
+				i += end + 2*len(tag)
+			}
+		case c == '?' && syntax.marks:
+			code = true
+			marks++
+			i++
+		case c == ';':
+			flush(i)
+			i++
+			start = i
+		default:
+			if c != ' ' && c != '\t' && c != '\n' && c != '\r' {
+				code = true
+			}
+			i++
+		}
+	}
+	flush(n)
+	return stmts
+}
+
+// dollarTag returns the tag like $$ or $body$ that s starts with
+func dollarTag(s string) string {
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		if c == '$' {
+			return s[:i+1]
+		}
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || i > 1 && c >= '0' && c <= '9') {
+			return ""
+		}
+	}
+	return ""
+}
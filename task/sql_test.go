@@ -0,0 +1,172 @@
+package task
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		driver string
+		script string
+		stmts  []sqlStatement
+	}{
+		{"sqlite3", "select 1", []sqlStatement{{"select 1", 0}}},
+		{"sqlite3", "select 1;\n select ?, ?; -- end;\n", []sqlStatement{{"select 1", 0}, {"select ?, ?", 2}}},
+		{"postgres", "insert into t values ('a;b', \"c\"\"d;\");/* x; */ select $2, $1, $12 - $1",
+			[]sqlStatement{{"insert into t values ('a;b', \"c\"\"d;\")", 0}, {"/* x; */ select $2, $1, $12 - $1", 12}}},
+		{"postgres", "create function f() returns int as $body$ select 1; $body$ language sql; select '?'",
+			[]sqlStatement{{"create function f() returns int as $body$ select 1; $body$ language sql", 0},
+				{"select '?'", 0}}},
+		{"sqlite3", ";; -- only comments\n", nil},
+		// ? and ?| are jsonb operators of postgres
+		{"postgres", "select data ? 'a', data ?| array['b'] from t where id = $1::int",
+			[]sqlStatement{{"select data ? 'a', data ?| array['b'] from t where id = $1::int", 1}}},
+		// named params can't be counted, casts aren't params
+		{"sqlite3", "select :a, @b; select a::text from t where b = ?",
+			[]sqlStatement{{"select :a, @b", -1}, {"select a::text from t where b = ?", 1}}},
+		{"godror", "select 'x' from dual where id = :1", []sqlStatement{{"select 'x' from dual where id = :1", -1}}},
+		// backslash escaped quotes of mysql, @a is a user variable
+		{"mysql", `insert into t values ('it\'s; x', "a\"; ?"); set @a = ?`,
+			[]sqlStatement{{`insert into t values ('it\'s; x', "a\"; ?")`, 0}, {"set @a = ?", 1}}},
+	}
+	for _, test := range tests {
+		if stmts := splitStatements(test.script, syntaxOf(test.driver)); !reflect.DeepEqual(stmts, test.stmts) {
+			t.Errorf("split %q: expect %+v, got %+v", test.script, test.stmts, stmts)
+		}
+	}
+}
+
+func TestSqlTask(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "init.sql")
+	err := ioutil.WriteFile(script, []byte(`
+		-- users of the test;
+		create table users (id int, name text);
+		insert into users values (1, 'a;b');
+	`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	after := func(deps ...interface{}) []interface{} {
+		return deps
+	}
+	d, err := CreateTaskDag(DagTaskConfig{
+		Mode:   ContinueOnError,
+		Params: map[string]string{"id": "2"},
+		Connections: map[string]*Connection{
+			"db": {Dialect: "sqlite3", Uri: filepath.Join(dir, "test.db"), MaxOpenConns: 2},
+		},
+		Tasks: []map[string]interface{}{
+			{"name": "init", "type": "sql", "conn": "db", "file": script},
+			{"name": "insert", "type": "sql", "conn": "db", "dependOn": after("init"),
+				"sql":  "insert into users values (?, ?); update users set name = upper(name)",
+				"args": []interface{}{"{params.id}", "c"}},
+			{"name": "rollback", "type": "sql", "conn": "db", "dependOn": after("insert"), "transaction": true,
+				"sql": "insert into users values (3, 'd'); insert into missing values (1)"},
+			{"name": "count", "type": "sql", "conn": "db", "dependOn": after("rollback"), "triggerRule": "all_done",
+				"sql": "select count(*) as n, max(name) as last from users", "sqloutput": "total",
+				"capture": map[string]interface{}{"last": "last"}},
+			{"name": "export", "type": "sql", "conn": "db", "dependOn": after("count"),
+				"sql": "select id, name from users order by id", "resultFile": filepath.Join(dir, "users.csv")},
+			{"name": "json", "type": "sql", "dialect": "sqlite3", "uri": filepath.Join(dir, "test.db"),
+				"dependOn": after("count"), "sql": "select id, name, null as x from users order by id",
+				"resultFile": filepath.Join(dir, "users.json")},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.SetLogger(nil)
+	if err := d.Run(); err == nil || !strings.Contains(err.Error(), "statement 2") {
+		t.Fatalf("unexpected error %v", err)
+	}
+	for _, tr := range d.Result().Tasks {
+		if tr.Name != "rollback" && tr.State != StateSucceeded {
+			t.Fatalf("task %s: %s %s", tr.Name, tr.State, tr.Error)
+		}
+		if tr.Name == "count" && (tr.Outputs["total"] != "2" || tr.Outputs["last"] != "C") {
+			t.Fatalf("unexpected outputs %v", tr.Outputs)
+		}
+	}
+	data, _ := ioutil.ReadFile(filepath.Join(dir, "users.csv"))
+	if string(data) != "id,name\n1,A;B\n2,C\n" {
+		t.Fatalf("unexpected csv %q", data)
+	}
+	data, _ = ioutil.ReadFile(filepath.Join(dir, "users.json"))
+	if string(data) != "[\n  {\"id\":1,\"name\":\"A;B\",\"x\":null},\n  {\"id\":2,\"name\":\"C\",\"x\":null}\n]\n" {
+		t.Fatalf("unexpected json %q", data)
+	}
+
+	bad := []map[string]interface{}{
+		{"name": "a", "type": "sql", "conn": "unknown", "sql": "select 1"},
+		{"name": "a", "type": "sql", "conn": "db", "dialect": "sqlite3", "sql": "select 1"},
+		{"name": "a", "type": "sql", "conn": "db"},
+		{"name": "a", "type": "sql", "conn": "db", "sql": "select 1", "resultFile": "out.txt"},
+	}
+	for _, tc := range bad {
+		_, err := CreateTaskDag(DagTaskConfig{
+			Connections: map[string]*Connection{"db": {Dialect: "sqlite3", Uri: ":memory:"}},
+			Tasks:       []map[string]interface{}{tc},
+		})
+		if err == nil {
+			t.Errorf("expect error of %v", tc)
+		}
+	}
+}
+
+func TestSqlTaskArgs(t *testing.T) {
+	script := "create table t (a int); insert into t values (?); insert into t values ($1);" +
+		"select group_concat(a) from t where a > ?"
+	tests := []struct {
+		args []interface{}
+		out  string
+		err  string
+	}{
+		{[]interface{}{1, 2, 0}, "1,2", ""},
+		{[]interface{}{1, 2}, "", "statement 4: expect 1 args, only 0 left"},
+		{[]interface{}{1, 2, 0, 3}, "", "expect 3 args by placeholders, got 4"},
+	}
+	for _, test := range tests {
+		d, err := CreateTaskDag(DagTaskConfig{
+			Tasks: []map[string]interface{}{{"name": "a", "type": "sql", "dialect": "sqlite3", "uri": ":memory:",
+				"sql": script, "args": test.args, "sqloutput": "out"}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		d.SetLogger(nil)
+		err = d.Run()
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("args %v: unexpected error %v", test.args, err)
+			}
+			continue
+		}
+		if out := d.Result().Tasks[0].Outputs["out"]; err != nil || out != test.out {
+			t.Errorf("args %v: unexpected result %q %v", test.args, out, err)
+		}
+	}
+}
+
+func TestSqlTaskNamedParams(t *testing.T) {
+	d, err := CreateTaskDag(DagTaskConfig{
+		Tasks: []map[string]interface{}{{"name": "a", "type": "sql", "dialect": "sqlite3", "uri": ":memory:",
+			"sql": "select 1; select :a || '-' || @b || ':' || 'c'", "args": []interface{}{"x", "y"}, "sqloutput": "out"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.SetLogger(nil)
+	if err := d.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if out := d.Result().Tasks[0].Outputs["out"]; out != "x-y:c" {
+		t.Fatalf("unexpected result %q", out)
+	}
+}
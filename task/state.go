@@ -0,0 +1,27 @@
+package task
+
+import (
+	"github.com/pkg/errors"
+)
+
+// TaskState is the state of a task in a run
+type TaskState string
+
+const (
+	StatePending   TaskState = "pending"
+	StateSucceeded TaskState = "succeeded"
+	StateFailed    TaskState = "failed"
+	// not run because the run was canceled before it started
+	StateSkipped TaskState = "skipped"
+	// stopped while running
+	StateCancelled TaskState = "cancelled"
+	// not run because a depended task failed
+	StateUpstreamFailed TaskState = "upstream_failed"
+)
+
+var (
+	// ErrTimeout is the cause of errors returned by tasks exceeding the timeout
+	ErrTimeout        = errors.New("task timed out")
+	ErrSkipped        = errors.New("task skipped")
+	ErrUpstreamFailed = errors.New("upstream task failed")
+)
@@ -0,0 +1,138 @@
+package task
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// SubDagTask runs another dag as one task, the dag comes from one of
+//
+//	workflow: PATH      a workflow file, a relative path in a workflow file is
+//	                    relative to that file
+//	tasks: [...]        tasks inlined like those of a workflow file
+//
+// params are passed to the sub dag and override its own params, mode is the
+// RunMode of the sub dag. The run id and the run date are those of the parent
+// run. Tasks of the sub dag run with the context and the default pool of the
+// parent, slots held by SubDagTask are released for them. Inline tasks may use
+// any pool of the parent, a workflow file shares pools with the same names.
+// Outputs of tasks of the sub dag are published as TASK.KEY, like
+// {tasks.load.outputs.copy.rows}.
+type SubDagTask struct {
+	baseTask
+	dag    *dagTask
+	params map[string]string
+}
+
+// workflowStackKey is set to workflow files including the sub dag, so that a
+// workflow including itself is detected
+const workflowStackKey = "_workflowStack"
+
+type SubDagConfig struct {
+	Workflow string            `task:"workflow" desc:"workflow file of the sub dag"`
+	Tasks    []interface{}     `task:"tasks" desc:"tasks of the sub dag"`
+	Mode     string            `task:"mode" enum:"fail-fast|continue" default:"fail-fast" desc:"run mode of the sub dag"`
+	Params   map[string]string `task:"params" desc:"params passed to the sub dag"`
+	// set by the parent dag
+	WorkflowStack []interface{} `task:"_workflowStack"`
+}
+
+func newSubDagTask(name string, config interface{}) (Task, error) {
+	conf, ok := config.(*SubDagConfig)
+	if !ok {
+		return nil, errors.New("failed to newSubDagTask, wrong config")
+	}
+	t := &SubDagTask{params: conf.Params}
+	t.name = name
+	if t.params == nil {
+		t.params = map[string]string{}
+	}
+	stack := conf.WorkflowStack
+	if (conf.Workflow == "") == (conf.Tasks == nil) {
+		return nil, errors.New("expect one of workflow and tasks")
+	}
+	var c DagTaskConfig
+	if conf.Workflow != "" {
+		path := conf.Workflow
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, parent := range stack {
+			if parent == abs {
+				return nil, fmt.Errorf("workflow %s includes itself", path)
+			}
+		}
+		if c, err = LoadWorkflowConfig(path); err != nil {
+			return nil, err
+		}
+		stack = append(append([]interface{}{}, stack...), abs)
+	} else {
+		for i, item := range conf.Tasks {
+			tc, ok := item.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("task %d of tasks should be a mapping", i)
+			}
+			tc = copyConfig(tc)
+			if dependOn, ok := tc["dependOn"]; ok {
+				deps, err := toStringList(dependOn)
+				if err != nil {
+					return nil, errors.Wrapf(err, "task %d of tasks: bad dependOn", i)
+				}
+				tc["dependOn"] = deps
+			}
+			c.Tasks = append(c.Tasks, tc)
+		}
+	}
+	for _, tc := range c.Tasks {
+		if tc["type"] == "dag" {
+			tc[workflowStackKey] = stack
+		}
+	}
+	c.Mode = RunMode(conf.Mode)
+	c.inheritPools = conf.Workflow == ""
+	d, err := CreateTaskDag(c)
+	if err != nil {
+		return nil, errors.Wrap(err, "sub dag")
+	}
+	t.dag = d
+	return t, nil
+}
+
+func (t *SubDagTask) Run(ctx context.Context) error {
+	t.dag.SetParams(t.params)
+	err := runSubDag(ctx, t.dag)
+	for _, node := range t.dag.Nodes() {
+		sub := node.(*task)
+		for k, v := range sub.copyOutputs() {
+			SetOutput(ctx, sub.Name()+"."+k, v)
+		}
+	}
+	return err
+}
+
+func (t *SubDagTask) subDag() *dagTask {
+	return t.dag
+}
+
+// runSubDag runs d as part of the running task, with pools, the logger and
+// variables of the parent run. Slots held by the task are released for tasks
+// of d.
+func runSubDag(ctx context.Context, d *dagTask) error {
+	if slot, ok := ctx.Value(poolSlotKey{}).(*poolSlot); ok {
+		slot.release()
+		d.setPools(slot.pools)
+	}
+	// log as the parent dag, outputs go to the parent task if no sink is set
+	if parent, ok := ctx.Value(outputsKey{}).(*task); ok {
+		d.SetLogger(parent.logger)
+	}
+	if vars := runVarsFrom(ctx); vars != nil {
+		d.SetRunID(vars.runID)
+		d.SetRunDate(vars.date)
+	}
+	return d.RunContext(ctx)
+}
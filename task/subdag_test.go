@@ -0,0 +1,111 @@
+package task
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const mainWorkflow = `
+concurrentLimit: 1
+params:
+  table: users
+tasks:
+  - name: load
+    type: dag
+    workflow: load.yaml
+    params:
+      table: "{params.table}"
+  - name: inline
+    type: dag
+    tasks:
+      - name: a
+        type: echo
+        echostr: "{name}"
+      - name: b
+        type: echo
+        echostr: b
+        dependOn: [a]
+  - name: after
+    type: echo
+    echostr: "{tasks.load.outputs.copy.rows}"
+    dependOn: [load]
+`
+
+func writeFile(t *testing.T, path, content string) {
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSubDagTask(t *testing.T) {
+	main := writeWorkflow(t, "main.yaml", mainWorkflow)
+	// the sub workflow is found relative to the main one
+	writeFile(t, filepath.Join(filepath.Dir(main), "load.yaml"), `
+params:
+  table: none
+tasks:
+  - name: copy
+    type: sh
+    shellcmd: echo "rows=3 {params.table}" > $TASK_OUTPUT
+  - name: check
+    type: echo
+    echostr: "{tasks.copy.outputs.rows}"
+    dependOn: copy
+`)
+	d, err := LoadWorkflow(main)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Run(); err != nil {
+		t.Fatal(err)
+	}
+	r := d.Result()
+	var load TaskResult
+	for _, tr := range r.Tasks {
+		if tr.Name == "load" {
+			load = tr
+		}
+	}
+	if len(load.Tasks) != 2 || load.Tasks[0].State != StateSucceeded || load.Outputs["copy.rows"] != "3 users" {
+		t.Fatalf("unexpected result of sub dag %+v", load)
+	}
+	if load.Tasks[0].Outputs["rows"] != "3 users" {
+		t.Fatalf("unexpected outputs %v", load.Tasks[0].Outputs)
+	}
+	var buf bytes.Buffer
+	r.WriteTable(&buf)
+	if !strings.Contains(buf.String(), "inline/b") {
+		t.Fatalf("expect tasks of sub dags in table %s", buf.String())
+	}
+	for _, tr := range r.Tasks {
+		if tr.Name == "after" && !strings.Contains(tr.Output, "3 users") {
+			t.Fatalf("unexpected output of after %q", tr.Output)
+		}
+		if tr.Name == "inline" && !strings.Contains(tr.Output, "a\nb") {
+			t.Fatalf("unexpected output of inline %q", tr.Output)
+		}
+	}
+}
+
+func TestSubDagTaskErrors(t *testing.T) {
+	self := writeWorkflow(t, "self.yaml", `
+tasks:
+  - name: again
+    type: dag
+    workflow: self.yaml
+`)
+	bad := []map[string]interface{}{
+		{"name": "a"},
+		{"name": "a", "workflow": self},
+		{"name": "a", "tasks": []interface{}{"x"}},
+		{"name": "a", "tasks": []interface{}{map[string]interface{}{"name": "b", "type": "unknown"}}},
+	}
+	for _, conf := range bad {
+		if _, err := newTask("dag", conf); err == nil {
+			t.Fatalf("expect error of %v", conf)
+		}
+	}
+}
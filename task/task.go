@@ -2,29 +2,29 @@ package task
 
 import (
 	"context"
-	"database/sql"
 	"fmt"
-	"log"
-	"os/exec"
 	"sync"
 
 	"github.com/pkg/errors"
-	"github.com/zxdvd/go-libs/std-helper/str"
 )
 
 type fnNewTask func(args ...interface{}) (Task, error)
 
 var registeredTasks = struct {
 	constructors map[string]fnNewTask
+	typed        map[string]*typedTask
 	lock         sync.Mutex
 }{
 	constructors: map[string]fnNewTask{},
+	typed:        map[string]*typedTask{},
 }
 
+// Register registers a task type whose constructor reads the config map
+// itself, RegisterConfig is preferred for new types
 func Register(typ string, newTask func(args ...interface{}) (Task, error)) error {
 	registeredTasks.lock.Lock()
 	defer registeredTasks.lock.Unlock()
-	if _, ok := registeredTasks.constructors[typ]; ok {
+	if isRegistered(typ) {
 		return fmt.Errorf("type %s registered!", typ)
 	}
 	registeredTasks.constructors[typ] = newTask
@@ -32,20 +32,34 @@ func Register(typ string, newTask func(args ...interface{}) (Task, error)) error
 }
 
 func init() {
-	Register("echo", newEchoTask)
-	Register("sh", NewShellTask)
-	Register("shell", NewShellTask)
-	Register("sql", newSqlTask)
-	log.Println("echo Register")
+	RegisterConfig("echo", &EchoConfig{}, newEchoTask)
+	RegisterConfig("sh", &ShellConfig{}, newShellTask)
+	RegisterConfig("shell", &ShellConfig{}, newShellTask)
+	RegisterConfig("sql", &SqlConfig{}, newSqlTask)
+	RegisterConfig("http", &HttpConfig{}, newHttpTask)
+	RegisterConfig("wait", &WaitConfig{}, newWaitTask)
+	RegisterConfig("dag", &SubDagConfig{}, newSubDagTask)
 }
 
-func newTask(typ string, config map[string]interface{}) (Task, error) {
+func newTask(typ string, config map[string]interface{}) (t Task, err error) {
 	registeredTasks.lock.Lock()
-	defer registeredTasks.lock.Unlock()
-	if new_, ok := registeredTasks.constructors[typ]; ok {
-		return new_(config)
+	typed := registeredTasks.typed[typ]
+	new_, ok := registeredTasks.constructors[typ]
+	registeredTasks.lock.Unlock()
+	// not locked while creating since it may create tasks too, like SubDagTask
+	if typed != nil {
+		return typed.create(config)
+	}
+	if !ok {
+		return nil, fmt.Errorf("type %s not registered!", typ)
 	}
-	return nil, fmt.Errorf("type %s not registered!", typ)
+	// constructors registered by Register may panic on bad configs
+	defer func() {
+		if r := recover(); r != nil {
+			t, err = nil, fmt.Errorf("bad config: %v", r)
+		}
+	}()
+	return new_(config)
 }
 
 type Task interface {
@@ -66,91 +80,24 @@ type EchoTask struct {
 	str string
 }
 
-func newEchoTask(data ...interface{}) (Task, error) {
-	conf, ok := data[0].(map[string]interface{})
+type EchoConfig struct {
+	Echostr string `task:"echostr,required" desc:"string to print"`
+}
+
+func newEchoTask(name string, config interface{}) (Task, error) {
+	c, ok := config.(*EchoConfig)
 	if !ok {
 		return nil, errors.New("failed to newEchoTask, wrong config")
 	}
 	return &EchoTask{
 		baseTask: baseTask{
-			name: conf["name"].(string),
+			name: name,
 		},
-		str: conf["echostr"].(string),
+		str: c.Echostr,
 	}, nil
 }
 
 func (t *EchoTask) Run(ctx context.Context) error {
-	fmt.Println(t.str)
-	return nil
-}
-
-type ShellTask struct {
-	baseTask
-	cmd string
-	cwd string
-}
-
-func NewShellTask(data ...interface{}) (Task, error) {
-	conf, ok := data[0].(map[string]interface{})
-	if !ok {
-		return nil, errors.New("failed to newShellTask, wrong config")
-	}
-	t := ShellTask{
-		baseTask: baseTask{
-			name: conf["name"].(string),
-		},
-		cmd: conf["shellcmd"].(string),
-	}
-	if cwd, ok := conf["shellcwd"].(string); ok {
-		t.cwd = cwd
-	}
-	return &t, nil
-}
-
-func (t *ShellTask) Run(ctx context.Context) error {
-	params := map[string]string{
-		"name": t.name,
-	}
-	cmd := str.StrReplace(t.cmd, params)
-	log.Println("------cmd", cmd)
-	command := exec.Command("sh", "-c", cmd)
-	command.Dir = t.cwd
-	out, err := command.CombinedOutput()
-	fmt.Println(string(out))
-	if err != nil {
-		return err
-	}
+	fmt.Fprintln(Output(ctx), t.str)
 	return nil
 }
-
-type SqlTask struct {
-	baseTask
-	dialect string
-	uri     string
-	Sql     string
-}
-
-func newSqlTask(data ...interface{}) (Task, error) {
-	conf, ok := data[0].(map[string]interface{})
-	if !ok {
-		return nil, errors.New("failed to newSqlTask, wrong config")
-	}
-	return &SqlTask{
-		baseTask: baseTask{
-			name: conf["name"].(string),
-		},
-		dialect: conf["dialect"].(string),
-		uri:     conf["uri"].(string),
-		Sql:     conf["sql"].(string),
-	}, nil
-}
-
-func (t *SqlTask) Run(ctx context.Context) error {
-	db, err := sql.Open(t.dialect, t.uri)
-	if err != nil {
-		return err
-	}
-	_, err = db.Exec(t.Sql)
-	log.Println("SqlTask error --------", err)
-	return err
-}
@@ -0,0 +1,168 @@
+package task
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Config strings may reference variables like {tasks.extract.outputs.path},
+// an optional format follows the colon, like {ds:YYYY-MM-DD}. Braces not
+// matching a known variable are kept as is, so that shell snippets like
+// ${HOME} still work. Known variables like ${name} in shell snippets are
+// replaced though, "{{" is the escape of a literal "{", like ${{name}.
+var patTemplateVar = regexp.MustCompile(`\{\{|\{([A-Za-z_][\w.-]*)(?::([^{}]*))?\}`)
+
+const templateEscape = "{{"
+
+// fields that are never templated
+var untemplatedFields = map[string]bool{
+	"name":        true,
+	"type":        true,
+	"dependOn":    true,
+	"triggerRule": true,
+	"when":        true,
+	"pools":       true,
+	"priority":    true,
+	// tasks of SubDagTask are templated by the sub dag
+	"tasks":          true,
+	workflowStackKey: true,
+}
+
+// lookupFunc returns the value of a variable, ok is false for unknown ones
+type lookupFunc func(name, format string) (value string, ok bool, err error)
+
+func expandString(s string, lookup lookupFunc) (string, error) {
+	return expander{lookup: lookup}.string(s)
+}
+
+func expandValue(v interface{}, lookup lookupFunc) (interface{}, error) {
+	return expander{lookup: lookup}.value(v)
+}
+
+// expandConfig returns a copy of the task config with variables replaced
+func expandConfig(conf map[string]interface{}, lookup lookupFunc) (map[string]interface{}, error) {
+	return expander{lookup: lookup}.config(conf)
+}
+
+// expander replaces variables by lookup, escapes are kept for configs that
+// are expanded again later, like instances of MapTask
+type expander struct {
+	lookup      lookupFunc
+	keepEscapes bool
+}
+
+func (e expander) string(s string) (string, error) {
+	var err error
+	result := patTemplateVar.ReplaceAllStringFunc(s, func(m string) string {
+		if m == templateEscape {
+			if e.keepEscapes {
+				return m
+			}
+			return "{"
+		}
+		if err != nil {
+			return m
+		}
+		sub := patTemplateVar.FindStringSubmatch(m)
+		value, ok, lerr := e.lookup(sub[1], sub[2])
+		if lerr != nil {
+			err = lerr
+		}
+		if !ok {
+			return m
+		}
+		return value
+	})
+	return result, err
+}
+
+func (e expander) value(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case string:
+		return e.string(v)
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			var err error
+			if list[i], err = e.value(item); err != nil {
+				return nil, err
+			}
+		}
+		return list, nil
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			var err error
+			if m[k], err = e.value(item); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	}
+	return v, nil
+}
+
+func (e expander) config(conf map[string]interface{}) (map[string]interface{}, error) {
+	expanded := make(map[string]interface{}, len(conf))
+	for k, v := range conf {
+		if untemplatedFields[k] {
+			expanded[k] = v
+			continue
+		}
+		var err error
+		if expanded[k], err = e.value(v); err != nil {
+			return nil, fmt.Errorf("field %s: %v", k, err)
+		}
+	}
+	return expanded, nil
+}
+
+// configVars returns names of all variables referenced by the task config
+func configVars(conf map[string]interface{}) []string {
+	var names []string
+	collect := func(name, format string) (string, bool, error) {
+		names = append(names, name)
+		return "", false, nil
+	}
+	expandConfig(conf, collect)
+	return names
+}
+
+// configHasEscapes tells whether strings of the config have escapes
+func configHasEscapes(conf map[string]interface{}) bool {
+	found := false
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch v := v.(type) {
+		case string:
+			found = found || strings.Contains(v, templateEscape)
+		case []interface{}:
+			for _, item := range v {
+				walk(item)
+			}
+		case map[string]interface{}:
+			for _, item := range v {
+				walk(item)
+			}
+		}
+	}
+	for k, v := range conf {
+		if !untemplatedFields[k] {
+			walk(v)
+		}
+	}
+	return found
+}
+
+// parseOutputVar parses variables like tasks.extract.outputs.path
+func parseOutputVar(name string) (taskName, key string, ok bool) {
+	if !strings.HasPrefix(name, "tasks.") {
+		return "", "", false
+	}
+	i := strings.Index(name, ".outputs.")
+	if i < 0 {
+		return "", "", false
+	}
+	return name[len("tasks."):i], name[i+len(".outputs."):], true
+}
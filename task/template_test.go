@@ -0,0 +1,81 @@
+package task
+
+import (
+	"testing"
+)
+
+func TestOutputVars(t *testing.T) {
+	d, err := CreateTaskDag(DagTaskConfig{
+		Tasks: []map[string]interface{}{
+			{"name": "extract", "type": "sh", "shellcmd": `echo /tmp/data; echo "rows=42" >> "$TASK_OUTPUT"`},
+			{"name": "middle", "type": "echo", "echostr": "x", "dependOn": []interface{}{"extract"}},
+			{"name": "load", "type": "sh", "dependOn": []interface{}{"middle"},
+				"shellcmd": `echo "{tasks.extract.outputs.stdout} {tasks.extract.outputs.rows} ${HOME:+home}"`},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Run(); err != nil {
+		t.Fatal(err)
+	}
+	r := d.Result()
+	if r.Tasks[0].Outputs["rows"] != "42" || r.Tasks[0].Outputs["stdout"] != "/tmp/data" {
+		t.Fatalf("unexpected outputs %v", r.Tasks[0].Outputs)
+	}
+	if r.Tasks[2].Output != "/tmp/data 42 home\n" {
+		t.Fatalf("unexpected output %q", r.Tasks[2].Output)
+	}
+
+	// missing output fails the task
+	d, _ = CreateTaskDag(DagTaskConfig{
+		Tasks: []map[string]interface{}{
+			{"name": "extract", "type": "echo", "echostr": "x"},
+			{"name": "load", "type": "echo", "echostr": "{tasks.extract.outputs.path}",
+				"dependOn": []interface{}{"extract"}},
+		},
+	})
+	if err := d.Run(); err == nil || err.Error() != "task load: field echostr: output path of task extract not found" {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// only outputs of upstream tasks can be referenced
+	_, err = CreateTaskDag(DagTaskConfig{
+		Tasks: []map[string]interface{}{
+			{"name": "extract", "type": "echo", "echostr": "x"},
+			{"name": "load", "type": "echo", "echostr": "{tasks.extract.outputs.path}"},
+		},
+	})
+	if _, ok := err.(*TaskConfigError); !ok {
+		t.Fatalf("expect config error, got %v", err)
+	}
+}
+
+func TestTemplateEscape(t *testing.T) {
+	d, err := CreateTaskDag(DagTaskConfig{
+		Tasks: []map[string]interface{}{
+			{"name": "greet", "type": "sh", "shellcmd": `name=x; echo "${{name} {name} {{{name}"`},
+			{"name": "plain", "type": "echo", "echostr": "{{x}"},
+			{"name": "each", "type": "echo", "mapOver": []interface{}{1}, "echostr": "{{item}={item}"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Run(); err != nil {
+		t.Fatal(err)
+	}
+	results := map[string]TaskResult{}
+	for _, tr := range d.Result().Tasks {
+		results[tr.Name] = tr
+	}
+	if output := results["greet"].Output; output != "x greet {greet\n" {
+		t.Fatalf("unexpected output %q", output)
+	}
+	if output := results["plain"].Output; output != "{x}\n" {
+		t.Fatalf("unexpected output of task without variables %q", output)
+	}
+	if output := results["each"].Tasks[0].Output; output != "{item}=1\n" {
+		t.Fatalf("unexpected output of map instance %q", output)
+	}
+}
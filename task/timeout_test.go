@@ -0,0 +1,66 @@
+package task
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestTaskTimeout(t *testing.T) {
+	cases := []DagTaskConfig{
+		{Tasks: []map[string]interface{}{
+			// the background sleep keeps the output pipe open unless the
+			// whole process group is killed
+			{"name": "slow", "type": "sh", "shellcmd": "sleep 5 & wait", "timeout": "100ms"},
+		}},
+		{Timeout: 100 * time.Millisecond, Tasks: []map[string]interface{}{
+			{"name": "slow", "type": "sh", "shellcmd": "sleep 5 & wait"},
+		}},
+		// timeout and retries are parsed for each run if they are templated
+		{Params: map[string]string{"timeout": "100ms", "retries": "0"}, Tasks: []map[string]interface{}{
+			{"name": "slow", "type": "sh", "shellcmd": "sleep 5 & wait", "timeout": "{params.timeout}",
+				"retries": "{params.retries}"},
+		}},
+	}
+	for _, c := range cases {
+		d, err := CreateTaskDag(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		start := time.Now()
+		err = d.Run()
+		if errors.Cause(err) != ErrTimeout {
+			t.Fatalf("expect timeout error, got %v", err)
+		}
+		if time.Since(start) > 2*time.Second {
+			t.Fatal("task not killed after timeout")
+		}
+		if !d.Nodes()[0].(*task).timedOut {
+			t.Fatal("task should be marked timed out")
+		}
+	}
+}
+
+func TestTemplatedTimeout(t *testing.T) {
+	d, err := CreateTaskDag(DagTaskConfig{
+		Params: map[string]string{"timeout": "soon"},
+		Tasks: []map[string]interface{}{
+			{"name": "a", "type": "echo", "echostr": "a", "timeout": "{params.timeout}"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.SetLogger(nil)
+	if _, err := d.Plan(); err == nil {
+		t.Fatal("expect error of bad timeout in plan")
+	}
+	if err := d.Run(); err == nil {
+		t.Fatal("expect error of bad timeout")
+	}
+	d.SetParams(map[string]string{"timeout": "1s"})
+	if err := d.Run(); err != nil || d.Nodes()[0].(*task).timeout != time.Second {
+		t.Fatalf("unexpected result %v", err)
+	}
+}
@@ -0,0 +1,87 @@
+package task
+
+import (
+	"context"
+	"fmt"
+)
+
+// TriggerRule decides whether a task runs by the states of the tasks it
+// depends on directly, tasks depending on nothing always run
+type TriggerRule string
+
+const (
+	// all depended tasks succeeded, the default
+	AllSuccess TriggerRule = "all_success"
+	// all depended tasks finished, whatever the states are
+	AllDone TriggerRule = "all_done"
+	// at least one depended task failed
+	OneFailed TriggerRule = "one_failed"
+	// at least one depended task succeeded
+	OneSuccess TriggerRule = "one_success"
+	// no depended task failed, skipped ones are fine
+	NoneFailed TriggerRule = "none_failed"
+)
+
+func (r TriggerRule) validate() error {
+	switch r {
+	case AllSuccess, AllDone, OneFailed, OneSuccess, NoneFailed:
+		return nil
+	}
+	return fmt.Errorf("unknown trigger rule %q", r)
+}
+
+// runsAfterFailure tells whether the task is meant to run when others fail,
+// such tasks still run after a fail-fast run is canceled
+func (r TriggerRule) runsAfterFailure() bool {
+	return r == AllDone || r == OneFailed
+}
+
+func isFailedState(s TaskState) bool {
+	return s == StateFailed || s == StateUpstreamFailed
+}
+
+// check tells whether to run, or the state of the task if it won't run
+func (r TriggerRule) check(deps []*task) (bool, TaskState) {
+	if len(deps) == 0 {
+		return true, ""
+	}
+	var succeeded, failed int
+	for _, dep := range deps {
+		switch {
+		case dep.state == StateSucceeded:
+			succeeded++
+		case isFailedState(dep.state):
+			failed++
+		}
+	}
+	// state of the task if it won't run
+	notRun := StateSkipped
+	if failed > 0 {
+		notRun = StateUpstreamFailed
+	}
+	switch r {
+	case AllDone:
+		return true, ""
+	case OneFailed:
+		return failed > 0, StateSkipped
+	case OneSuccess:
+		return succeeded > 0, notRun
+	case NoneFailed:
+		return failed == 0, notRun
+	}
+	return succeeded == len(deps), notRun
+}
+
+type baseContextKey struct{}
+
+// withBaseContext keeps base in ctx, base is not canceled by fail-fast
+func withBaseContext(ctx, base context.Context) context.Context {
+	return context.WithValue(ctx, baseContextKey{}, base)
+}
+
+func baseContext(ctx context.Context) context.Context {
+	if base, ok := ctx.Value(baseContextKey{}).(context.Context); ok {
+		return base
+	}
+	return ctx
+}
@@ -0,0 +1,103 @@
+package task
+
+import (
+	"testing"
+)
+
+func TestTriggerRules(t *testing.T) {
+	for _, mode := range []RunMode{FailFast, ContinueOnError} {
+		d, err := CreateTaskDag(DagTaskConfig{
+			Mode:   mode,
+			Params: map[string]string{"env": "dev"},
+			Tasks: []map[string]interface{}{
+				{"name": "ok", "type": "sh", "shellcmd": `echo "rows=3" >> $TASK_OUTPUT`},
+				{"name": "fail", "type": "sh", "shellcmd": "sleep 0.1; exit 1"},
+				{"name": "prod_only", "type": "echo", "echostr": "x", "when": `params.env == "prod"`},
+				{"name": "enough_rows", "type": "echo", "echostr": "x", "dependOn": []interface{}{"ok"},
+					"when": "tasks.ok.outputs.rows >= 3 && !(params.env == 'prod')"},
+				{"name": "after_skipped", "type": "echo", "echostr": "x", "dependOn": []interface{}{"prod_only"}},
+				{"name": "none_failed", "type": "echo", "echostr": "x", "triggerRule": "none_failed",
+					"dependOn": []interface{}{"ok", "prod_only"}},
+				{"name": "cleanup", "type": "echo", "echostr": "x", "triggerRule": "all_done",
+					"dependOn": []interface{}{"ok", "fail"}},
+				{"name": "alert", "type": "echo", "echostr": "x", "triggerRule": "one_failed",
+					"dependOn": []interface{}{"ok", "fail"}},
+				{"name": "no_alert", "type": "echo", "echostr": "x", "triggerRule": "one_failed",
+					"dependOn": []interface{}{"ok"}},
+				{"name": "one_success", "type": "echo", "echostr": "x", "triggerRule": "one_success",
+					"dependOn": []interface{}{"ok", "fail"}},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := d.Run(); err == nil {
+			t.Fatal("task fail should fail the run")
+		}
+		expect := map[string]TaskState{
+			"ok":            StateSucceeded,
+			"fail":          StateFailed,
+			"prod_only":     StateSkipped,
+			"enough_rows":   StateSucceeded,
+			"after_skipped": StateSkipped,
+			"none_failed":   StateSucceeded,
+			"cleanup":       StateSucceeded,
+			"alert":         StateSucceeded,
+			"no_alert":      StateSkipped,
+			"one_success":   StateSucceeded,
+		}
+		if mode == FailFast {
+			// not started when the run is canceled
+			expect["one_success"] = StateSkipped
+		}
+		if states := taskStates(d); !equalStates(states, expect) {
+			t.Fatalf("%s: unexpected states %v", mode, states)
+		}
+	}
+}
+
+func TestBadTrigger(t *testing.T) {
+	confs := []map[string]interface{}{
+		{"name": "a", "type": "echo", "echostr": "x", "triggerRule": "sometimes"},
+		{"name": "a", "type": "echo", "echostr": "x", "when": "params.x =="},
+		{"name": "a", "type": "echo", "echostr": "x", "when": "foo == 1"},
+		{"name": "a", "type": "echo", "echostr": "x", "when": "tasks.b.outputs.x == 1"},
+	}
+	for _, conf := range confs {
+		if _, err := CreateTaskDag(DagTaskConfig{Tasks: []map[string]interface{}{conf}}); err == nil {
+			t.Fatalf("%v: expect error", conf)
+		}
+	}
+}
+
+func TestExpr(t *testing.T) {
+	vars := map[string]string{"params.a": "10", "params.b": "9", "params.s": "abc", "params.empty": ""}
+	lookup := func(name, format string) (string, bool, error) {
+		v, ok := vars[name]
+		return v, ok, nil
+	}
+	cases := map[string]bool{
+		"params.a > params.b":                      true,
+		"params.a > 9.5 && params.s == 'abc'":      true,
+		"params.s < \"abd\"":                       true,
+		"params.empty || false":                    false,
+		"!params.empty":                            true,
+		"(params.a == 1 || params.b == 9) && true": true,
+		"params.a != 10":                           false,
+		"params.b >= -1":                           true,
+	}
+	for s, expect := range cases {
+		e, err := parseExpr(s)
+		if err != nil {
+			t.Fatalf("%s: %v", s, err)
+		}
+		if v, err := evalBool(e, lookup); err != nil || v != expect {
+			t.Fatalf("%s: expect %v, got %v %v", s, expect, v, err)
+		}
+	}
+	for _, s := range []string{"", "(params.a", "params.a ==", "'abc", "params.a # 1"} {
+		if _, err := parseExpr(s); err == nil {
+			t.Fatalf("%s: expect error", s)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package task
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/zxdvd/go-libs/datetime"
+	"gopkg.in/yaml.v3"
+)
+
+// Variables of a run that can be used in any string field of task configs
+//
+//	{name}          name of the task
+//	{run_id}        id of the run
+//	{ds}            date of the run as YYYY-MM-DD
+//	{ds:FORMAT}     date of the run formatted by datetime.Format, like {ds:YYYYMMDD}
+//	{params.KEY}    parameter of the run
+//	{env.KEY}       environment variable
+//
+// and {tasks.NAME.outputs.KEY} for outputs of upstream tasks. Fields needed
+// before runs, name, type, dependOn, triggerRule, when, pools and priority,
+// are not templated, while timeout and retry fields are parsed for each run
+// if they reference variables.
+type runVars struct {
+	runID  string
+	date   time.Time
+	params map[string]string
+}
+
+const defaultDateFormat = "YYYY-MM-DD"
+
+func isTemplateVar(name string) bool {
+	switch name {
+	case "name", "run_id", "ds":
+		return true
+	}
+	if _, _, ok := parseOutputVar(name); ok {
+		return true
+	}
+	return strings.HasPrefix(name, "params.") || strings.HasPrefix(name, "env.")
+}
+
+// lookup resolves run level variables, outputs of tasks are not handled here
+func (v *runVars) lookup(taskName, name, format string) (string, bool, error) {
+	switch {
+	case name == "name":
+		return taskName, true, nil
+	case name == "run_id":
+		return v.runID, true, nil
+	case name == "ds":
+		if format == "" {
+			format = defaultDateFormat
+		}
+		return datetime.Format(v.date, format), true, nil
+	case strings.HasPrefix(name, "params."):
+		key := name[len("params."):]
+		value, ok := v.params[key]
+		if !ok {
+			return "", false, fmt.Errorf("param %s not set", key)
+		}
+		return value, true, nil
+	case strings.HasPrefix(name, "env."):
+		return os.Getenv(name[len("env."):]), true, nil
+	}
+	return "", false, nil
+}
+
+type runVarsKey struct{}
+
+func withRunVars(ctx context.Context, v *runVars) context.Context {
+	return context.WithValue(ctx, runVarsKey{}, v)
+}
+
+// runVarsFrom returns variables of the run of the running task, nil if ctx
+// isn't from a run
+func runVarsFrom(ctx context.Context) *runVars {
+	v, _ := ctx.Value(runVarsKey{}).(*runVars)
+	return v
+}
+
+func newRunID(t time.Time) string {
+	return datetime.Format(t, "YYYYMMDD-HHmmss.SSS")
+}
+
+// SetRunID sets the id of following runs, a time based id is generated for
+// each run by default
+func (d *dagTask) SetRunID(id string) {
+	d.runID = id
+}
+
+// SetParams sets parameters of following runs, they override params of the
+// DagTaskConfig with the same key
+func (d *dagTask) SetParams(params map[string]string) {
+	for k, v := range params {
+		d.params[k] = v
+	}
+}
+
+// SetRunDate sets the date of following runs, it's the time when a run starts
+// by default
+func (d *dagTask) SetRunDate(date time.Time) {
+	d.runDate = date
+}
+
+// LoadParams reads parameters from a flat yaml, json or toml file
+func LoadParams(path string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]interface{}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml", ".json":
+		// json is valid yaml
+		err = yaml.Unmarshal(data, &raw)
+	case ".toml":
+		_, err = toml.Decode(string(data), &raw)
+	default:
+		err = fmt.Errorf("unknown params format %q", ext)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return toParams(raw)
+}
+
+func toParams(raw map[string]interface{}) (map[string]string, error) {
+	params := make(map[string]string, len(raw))
+	for k, v := range raw {
+		switch v.(type) {
+		case []interface{}, map[string]interface{}:
+			return nil, fmt.Errorf("param %s should be a scalar value", k)
+		}
+		params[k] = fmt.Sprint(v)
+	}
+	return params, nil
+}
@@ -0,0 +1,61 @@
+package task
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRunVars(t *testing.T) {
+	os.Setenv("TASK_TEST_ENV", "env")
+	d, err := CreateTaskDag(DagTaskConfig{
+		Params: map[string]string{"region": "eu", "table": "events"},
+		Tasks: []map[string]interface{}{
+			{"name": "hello", "type": "echo",
+				"echostr": "{name} {run_id} {ds} {ds:YYYYMMDD} {params.region} {params.table} {env.TASK_TEST_ENV} {unknown}"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.SetRunID("run1")
+	d.SetRunDate(time.Date(2019, 12, 4, 0, 0, 0, 0, time.UTC))
+	d.SetParams(map[string]string{"table": "users"})
+	if err := d.Run(); err != nil {
+		t.Fatal(err)
+	}
+	r := d.Result()
+	expect := "hello run1 2019-12-04 20191204 eu users env {unknown}\n"
+	if r.RunID != "run1" || r.Tasks[0].Output != expect {
+		t.Fatalf("unexpected output %q", r.Tasks[0].Output)
+	}
+
+	// a missing param fails the run before any task starts
+	d, _ = CreateTaskDag(DagTaskConfig{
+		Tasks: []map[string]interface{}{
+			{"name": "first", "type": "echo", "echostr": "first"},
+			{"name": "second", "type": "echo", "echostr": "{params.missing}", "dependOn": []interface{}{"first"}},
+		},
+	})
+	if err := d.Run(); err == nil {
+		t.Fatal("expect error of missing param")
+	}
+	if state := d.Nodes()[0].(*task).state; state != StatePending {
+		t.Fatalf("task should not run, got state %s", state)
+	}
+}
+
+func TestLoadParams(t *testing.T) {
+	path := writeWorkflow(t, "params.yaml", "region: eu\nlimit: 10\n")
+	params, err := LoadParams(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params["region"] != "eu" || params["limit"] != "10" {
+		t.Fatalf("unexpected params %v", params)
+	}
+	path = writeWorkflow(t, "params.toml", "region = \"eu\"\nlimit = 10\n")
+	if params, err = LoadParams(path); err != nil || params["limit"] != "10" {
+		t.Fatalf("unexpected params %v, %v", params, err)
+	}
+}
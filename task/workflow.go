@@ -0,0 +1,482 @@
+package task
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// A workflow file describes a DagTaskConfig, for example in yaml
+//
+//   concurrentLimit: 2
+//   pools:
+//     db: 1
+//   connections:
+//     warehouse:
+//       dialect: postgres
+//       uri: postgres://localhost/dev
+//   timeout: 1h
+//   mode: continue
+//   params:
+//     db: postgres://localhost/dev
+//   schedule:
+//     cron: "0 2 * * *"
+//     timezone: Asia/Shanghai
+//   tasks:
+//     - name: extract
+//       type: sh
+//       shellcmd: ./extract.sh
+//     - name: load
+//       type: sql
+//       dependOn: [extract]
+//       pools: db
+//       priority: 10
+//       conn: warehouse
+//       file: load.sql
+//
+// json and toml (using [[tasks]] tables) are supported too, the format is
+// chosen by the file extension.
+//
+// Relative paths of task configs, see workflowPathFields, are relative to the
+// directory of the workflow file instead of the working directory. Paths
+// starting with a variable like {params.dir}/out.csv are kept as is, and
+// stdinFile stays relative to shellcwd if it's set.
+
+// WorkflowError is returned when loading a workflow file fails, Line and Task
+// are set when the error can be located.
+type WorkflowError struct {
+	File string
+	Line int
+	Task string
+	Err  error
+}
+
+func (e *WorkflowError) Error() string {
+	var b strings.Builder
+	b.WriteString(e.File)
+	if e.Line > 0 {
+		fmt.Fprintf(&b, ":%d", e.Line)
+	}
+	if e.Task != "" {
+		fmt.Fprintf(&b, ": task %s", e.Task)
+	}
+	fmt.Fprintf(&b, ": %v", e.Err)
+	return b.String()
+}
+
+func (e *WorkflowError) Cause() error {
+	return e.Err
+}
+
+// WorkflowErrors is returned when several tasks of a workflow file are invalid
+type WorkflowErrors []*WorkflowError
+
+func (e WorkflowErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// keys allowed at the top level of a workflow file
+var workflowKeys = map[string]bool{
+	"tasks":           true,
+	"concurrentLimit": true,
+	"timeout":         true,
+	"mode":            true,
+	"params":          true,
+	"schedule":        true,
+	"pools":           true,
+	"connections":     true,
+}
+
+type workflowFile struct {
+	path  string
+	top   map[string]interface{}
+	tasks []map[string]interface{}
+	// line of each task in the file, 0 if unknown
+	lines []int
+}
+
+func (w *workflowFile) taskError(err error, index int) *WorkflowError {
+	e := &WorkflowError{File: w.path, Err: err}
+	if index >= 0 && index < len(w.tasks) {
+		e.Task, _ = w.tasks[index]["name"].(string)
+		if index < len(w.lines) {
+			e.Line = w.lines[index]
+		}
+	}
+	return e
+}
+
+func (w *workflowFile) config() (DagTaskConfig, error) {
+	c := DagTaskConfig{}
+	for k := range w.top {
+		if !workflowKeys[k] {
+			return c, w.taskError(fmt.Errorf("unknown field %s", k), -1)
+		}
+	}
+	if v, ok := w.top["concurrentLimit"]; ok {
+		n, ok := toInt(v)
+		if !ok || n < 0 {
+			return c, w.taskError(fmt.Errorf("concurrentLimit should be a positive integer, got %v", v), -1)
+		}
+		c.ConcurrentLimit = n
+	}
+	if v, ok := w.top["timeout"]; ok {
+		d, err := toDuration(v)
+		if err != nil {
+			return c, w.taskError(errors.Wrap(err, "bad timeout"), -1)
+		}
+		c.Timeout = d
+	}
+	if v, ok := w.top["params"]; ok {
+		raw, ok := v.(map[string]interface{})
+		if !ok {
+			return c, w.taskError(fmt.Errorf("params should be a mapping, got %v", v), -1)
+		}
+		params, err := toParams(raw)
+		if err != nil {
+			return c, w.taskError(err, -1)
+		}
+		c.Params = params
+	}
+	if v, ok := w.top["schedule"]; ok {
+		schedule, err := newSchedule(v)
+		if err != nil {
+			return c, w.taskError(err, -1)
+		}
+		c.Schedule = schedule
+	}
+	if v, ok := w.top["pools"]; ok {
+		raw, ok := v.(map[string]interface{})
+		if !ok {
+			return c, w.taskError(fmt.Errorf("pools should be a mapping, got %v", v), -1)
+		}
+		c.Pools = map[string]int{}
+		for name, size := range raw {
+			n, ok := toInt(size)
+			if !ok || n < 1 {
+				return c, w.taskError(fmt.Errorf("size of pool %s should be a positive integer, got %v", name, size), -1)
+			}
+			c.Pools[name] = n
+		}
+	}
+	if v, ok := w.top["connections"]; ok {
+		raw, ok := v.(map[string]interface{})
+		if !ok {
+			return c, w.taskError(fmt.Errorf("connections should be a mapping, got %v", v), -1)
+		}
+		c.Connections = map[string]*Connection{}
+		for name, conf := range raw {
+			conn, err := newConnection(name, conf)
+			if err != nil {
+				return c, w.taskError(err, -1)
+			}
+			c.Connections[name] = conn
+		}
+	}
+	if v, ok := w.top["mode"]; ok {
+		mode, _ := v.(string)
+		c.Mode = RunMode(mode)
+		if err := c.Mode.validate(); err != nil {
+			return c, w.taskError(err, -1)
+		}
+	}
+	names := map[string]bool{}
+	for i, tc := range w.tasks {
+		name, ok := tc["name"].(string)
+		if !ok || name == "" {
+			return c, w.taskError(errors.New("task name missing"), i)
+		}
+		if names[name] {
+			return c, w.taskError(errors.New("duplicated task name"), i)
+		}
+		names[name] = true
+		if _, ok := tc["type"].(string); !ok {
+			return c, w.taskError(errors.New("task type missing"), i)
+		}
+		if dependOn, ok := tc["dependOn"]; ok {
+			deps, err := toStringList(dependOn)
+			if err != nil {
+				return c, w.taskError(errors.Wrap(err, "bad dependOn"), i)
+			}
+			tc["dependOn"] = deps
+		}
+	}
+	for i, tc := range w.tasks {
+		deps, _ := tc["dependOn"].([]interface{})
+		for _, dep := range deps {
+			if !names[dep.(string)] {
+				return c, w.taskError(fmt.Errorf("depended task %s not found", dep), i)
+			}
+		}
+	}
+	resolveWorkflowPaths(w.tasks, filepath.Dir(w.path))
+	c.Tasks = w.tasks
+	return c, nil
+}
+
+// fields of task types that are paths
+var workflowPathFields = map[string][]string{
+	"sql":   {"file", "resultFile"},
+	"sh":    {"shellcwd", "stdinFile"},
+	"shell": {"shellcwd", "stdinFile"},
+	"http":  {"caFile", "certFile", "keyFile"},
+	"wait":  {"file", "caFile", "certFile", "keyFile"},
+	"dag":   {"workflow"},
+}
+
+// resolveWorkflowPaths makes relative paths of task configs relative to dir
+// instead of the working directory, including inline tasks of sub dags
+func resolveWorkflowPaths(tasks []map[string]interface{}, dir string) {
+	for _, tc := range tasks {
+		typ, _ := tc["type"].(string)
+		for _, field := range workflowPathFields[typ] {
+			if field == "stdinFile" && tc["shellcwd"] != nil {
+				continue
+			}
+			path, ok := tc[field].(string)
+			if !ok || path == "" || filepath.IsAbs(path) || strings.HasPrefix(path, "{") {
+				continue
+			}
+			tc[field] = filepath.Join(dir, path)
+		}
+		if typ != "dag" {
+			continue
+		}
+		inline, _ := tc["tasks"].([]interface{})
+		var subs []map[string]interface{}
+		for _, item := range inline {
+			if sub, ok := item.(map[string]interface{}); ok {
+				subs = append(subs, sub)
+			}
+		}
+		resolveWorkflowPaths(subs, dir)
+	}
+}
+
+func readWorkflow(path string) (*workflowFile, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var w *workflowFile
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		w, err = parseYAMLWorkflow(data)
+	case ".json":
+		w, err = parseJSONWorkflow(data)
+	case ".toml":
+		w, err = parseTOMLWorkflow(data)
+	default:
+		err = fmt.Errorf("unknown workflow format %q", ext)
+	}
+	if err != nil {
+		if e, ok := err.(*WorkflowError); ok {
+			e.File = path
+			return nil, e
+		}
+		return nil, &WorkflowError{File: path, Err: err}
+	}
+	w.path = path
+	return w, nil
+}
+
+// LoadWorkflowConfig reads a yaml, json or toml workflow file
+func LoadWorkflowConfig(path string) (DagTaskConfig, error) {
+	w, err := readWorkflow(path)
+	if err != nil {
+		return DagTaskConfig{}, err
+	}
+	return w.config()
+}
+
+// LoadWorkflow reads a workflow file and creates the task dag from it
+func LoadWorkflow(path string) (*dagTask, error) {
+	w, err := readWorkflow(path)
+	if err != nil {
+		return nil, err
+	}
+	c, err := w.config()
+	if err != nil {
+		return nil, err
+	}
+	d, err := CreateTaskDag(c)
+	if err != nil {
+		switch e := err.(type) {
+		case *TaskConfigError:
+			return nil, w.taskError(e.Err, e.Index)
+		case TaskConfigErrors:
+			errs := make(WorkflowErrors, len(e))
+			for i, te := range e {
+				errs[i] = w.taskError(te.Err, te.Index)
+			}
+			return nil, errs
+		}
+		return nil, w.taskError(err, -1)
+	}
+	return d, nil
+}
+
+func parseYAMLWorkflow(data []byte) (*workflowFile, error) {
+	w := &workflowFile{top: map[string]interface{}{}}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 {
+		return w, nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, &WorkflowError{Line: root.Line, Err: errors.New("workflow should be a mapping")}
+	}
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, val := root.Content[i], root.Content[i+1]
+		if key.Value != "tasks" {
+			var v interface{}
+			if err := val.Decode(&v); err != nil {
+				return nil, &WorkflowError{Line: val.Line, Err: err}
+			}
+			w.top[key.Value] = v
+			continue
+		}
+		if val.Kind != yaml.SequenceNode {
+			return nil, &WorkflowError{Line: val.Line, Err: errors.New("tasks should be a list")}
+		}
+		for _, item := range val.Content {
+			tc := map[string]interface{}{}
+			if err := item.Decode(&tc); err != nil {
+				return nil, &WorkflowError{Line: item.Line, Err: err}
+			}
+			w.tasks = append(w.tasks, tc)
+			w.lines = append(w.lines, item.Line)
+		}
+	}
+	return w, nil
+}
+
+func parseJSONWorkflow(data []byte) (*workflowFile, error) {
+	w := &workflowFile{top: map[string]interface{}{}}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	wrap := func(err error) error {
+		switch e := err.(type) {
+		case *json.SyntaxError:
+			return &WorkflowError{Line: lineAt(data, e.Offset), Err: err}
+		case *json.UnmarshalTypeError:
+			return &WorkflowError{Line: lineAt(data, e.Offset), Err: err}
+		}
+		return err
+	}
+	if err := expectDelim(dec, '{'); err != nil {
+		return nil, wrap(err)
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, wrap(err)
+		}
+		key, _ := tok.(string)
+		if key != "tasks" {
+			var v interface{}
+			if err := dec.Decode(&v); err != nil {
+				return nil, wrap(err)
+			}
+			w.top[key] = v
+			continue
+		}
+		if err := expectDelim(dec, '['); err != nil {
+			return nil, wrap(errors.Wrap(err, "tasks should be a list"))
+		}
+		for dec.More() {
+			line := lineAt(data, dec.InputOffset())
+			tc := map[string]interface{}{}
+			if err := dec.Decode(&tc); err != nil {
+				return nil, &WorkflowError{Line: line, Err: err}
+			}
+			w.tasks = append(w.tasks, tc)
+			w.lines = append(w.lines, line)
+		}
+		if err := expectDelim(dec, ']'); err != nil {
+			return nil, wrap(err)
+		}
+	}
+	return w, nil
+}
+
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if d, ok := tok.(json.Delim); !ok || d != delim {
+		return fmt.Errorf("expect %v, got %v", delim, tok)
+	}
+	return nil
+}
+
+// lineAt returns the line of the first token at or after offset
+func lineAt(data []byte, offset int64) int {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	i := int(offset)
+	for i < len(data) && strings.IndexByte(" \t\r\n,", data[i]) >= 0 {
+		i++
+	}
+	if i == len(data) {
+		i = int(offset)
+	}
+	return 1 + bytes.Count(data[:i], []byte("\n"))
+}
+
+var patTOMLTaskTable = regexp.MustCompile(`^\s*\[\[\s*tasks\s*\]\]`)
+
+func parseTOMLWorkflow(data []byte) (*workflowFile, error) {
+	w := &workflowFile{}
+	if _, err := toml.Decode(string(data), &w.top); err != nil {
+		return nil, err
+	}
+	if w.top == nil {
+		w.top = map[string]interface{}{}
+	}
+	switch tasks := w.top["tasks"].(type) {
+	case nil:
+	case []map[string]interface{}:
+		w.tasks = tasks
+	case []interface{}:
+		for _, item := range tasks {
+			tc, ok := item.(map[string]interface{})
+			if !ok {
+				return nil, errors.New("tasks should be a list of tables")
+			}
+			w.tasks = append(w.tasks, tc)
+		}
+	default:
+		return nil, errors.New("tasks should be a list of tables")
+	}
+	delete(w.top, "tasks")
+	// toml decoder doesn't keep positions, find lines of [[tasks]] headers
+	var lines []int
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		if patTOMLTaskTable.Match(scanner.Bytes()) {
+			lines = append(lines, n)
+		}
+	}
+	if len(lines) == len(w.tasks) {
+		w.lines = lines
+	}
+	return w, nil
+}
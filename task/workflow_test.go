@@ -0,0 +1,189 @@
+package task
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeWorkflow(t *testing.T, name, content string) string {
+	dir := t.TempDir()
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadWorkflow(t *testing.T) {
+	files := map[string]string{
+		"wf.yaml": `
+concurrentLimit: 2
+tasks:
+  - name: a
+    type: echo
+    echostr: hello
+  - name: b
+    type: echo
+    echostr: world
+    dependOn: a
+`,
+		"wf.json": `{
+  "concurrentLimit": 2,
+  "tasks": [
+    {"name": "a", "type": "echo", "echostr": "hello"},
+    {"name": "b", "type": "echo", "echostr": "world", "dependOn": ["a"]}
+  ]
+}`,
+		"wf.toml": `
+concurrentLimit = 2
+
+[[tasks]]
+name = "a"
+type = "echo"
+echostr = "hello"
+
+[[tasks]]
+name = "b"
+type = "echo"
+echostr = "world"
+dependOn = ["a"]
+`,
+	}
+	for name, content := range files {
+		path := writeWorkflow(t, name, content)
+		c, err := LoadWorkflowConfig(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.ConcurrentLimit != 2 || len(c.Tasks) != 2 {
+			t.Fatalf("%s: wrong config %v", name, c)
+		}
+		d, err := LoadWorkflow(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(d.Nodes()) != 2 || d.pools[DefaultPool].limit != 2 {
+			t.Fatalf("%s: wrong dag", name)
+		}
+		if err := d.Run(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLoadWorkflowError(t *testing.T) {
+	files := map[string]string{
+		"wf.yaml": `
+tasks:
+  - name: a
+    type: echo
+    echostr: hello
+  - name: b
+    type: echo
+    echostr: world
+    dependOn: [c]
+`,
+		"wf.json": `{
+  "tasks": [
+    {"name": "a", "type": "echo", "echostr": "hello"},
+    {"name": "b", "type": "echo", "echostr": "world",
+     "dependOn": ["c"]}
+  ]
+}`,
+		"wf.toml": `
+[[tasks]]
+name = "a"
+type = "echo"
+echostr = "hello"
+
+[[tasks]]
+name = "b"
+type = "echo"
+echostr = "world"
+dependOn = ["c"]
+`,
+	}
+	lines := map[string]int{"wf.yaml": 6, "wf.json": 4, "wf.toml": 7}
+	for name, content := range files {
+		path := writeWorkflow(t, name, content)
+		_, err := LoadWorkflow(path)
+		e, ok := err.(*WorkflowError)
+		if !ok {
+			t.Fatalf("%s: expect WorkflowError, got %v", name, err)
+		}
+		if e.Line != lines[name] || e.Task != "b" || !strings.HasPrefix(e.Error(), path) {
+			t.Fatalf("%s: wrong error %v", name, e)
+		}
+	}
+}
+
+func TestWorkflowConnections(t *testing.T) {
+	path := writeWorkflow(t, "wf.yaml", `
+connections:
+  db:
+    dialect: sqlite3
+    uri: test.db
+    maxOpenConns: 2
+tasks:
+  - name: load
+    type: sql
+    conn: db
+    file: load.sql
+`)
+	c, err := LoadWorkflowConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn := c.Connections["db"]; conn == nil || conn.Dialect != "sqlite3" || conn.MaxOpenConns != 2 {
+		t.Fatalf("unexpected connections %v", c.Connections)
+	}
+	// script files are relative to the workflow file
+	if c.Tasks[0]["file"] != filepath.Join(filepath.Dir(path), "load.sql") {
+		t.Fatalf("unexpected file %v", c.Tasks[0]["file"])
+	}
+
+	path = writeWorkflow(t, "wf.yaml", `
+connections:
+  db: {dialect: sqlite3, url: test.db}
+tasks:
+  - {name: load, type: sql, conn: db, sql: select 1}
+`)
+	if _, err := LoadWorkflow(path); err == nil || !strings.Contains(err.Error(), "url: unknown field") {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestResolveWorkflowPaths(t *testing.T) {
+	tasks := []map[string]interface{}{
+		{"name": "q", "type": "sql", "file": "q.sql", "resultFile": "/tmp/out.csv"},
+		{"name": "s", "type": "sh", "shellcwd": "scripts", "stdinFile": "input"},
+		{"name": "in", "type": "sh", "stdinFile": "input"},
+		{"name": "h", "type": "http", "caFile": "ca.pem", "certFile": "{params.certs}/cert.pem"},
+		{"name": "w", "type": "wait", "file": "done", "keyFile": "key.pem"},
+		{"name": "d", "type": "dag", "workflow": "sub.yaml", "tasks": []interface{}{
+			map[string]interface{}{"name": "sub", "type": "sql", "resultFile": "out.csv"},
+		}},
+	}
+	resolveWorkflowPaths(tasks, "/wf")
+	expected := []map[string]string{
+		{"file": "/wf/q.sql", "resultFile": "/tmp/out.csv"},
+		{"shellcwd": "/wf/scripts", "stdinFile": "input"},
+		{"stdinFile": "/wf/input"},
+		{"caFile": "/wf/ca.pem", "certFile": "{params.certs}/cert.pem"},
+		{"file": "/wf/done", "keyFile": "/wf/key.pem"},
+		{"workflow": "/wf/sub.yaml"},
+	}
+	for i, fields := range expected {
+		for k, v := range fields {
+			if tasks[i][k] != v {
+				t.Fatalf("task %s: expect %s %s, got %v", tasks[i]["name"], k, v, tasks[i][k])
+			}
+		}
+	}
+	sub := tasks[5]["tasks"].([]interface{})[0].(map[string]interface{})
+	if sub["resultFile"] != "/wf/out.csv" {
+		t.Fatalf("unexpected path of inline task %v", sub["resultFile"])
+	}
+}